@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Accumulator buffers items in a concurrent slice and hands them to a flush
+// function in batches, whenever the buffer reaches a size threshold or a
+// time interval elapses, whichever comes first.
+type Accumulator struct {
+	items     *ConcurrentSlice
+	size      int
+	flush     func(items []interface{})
+	flushLock sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	// closed is guarded by the lock of items.
+	closed bool
+}
+
+// NewAccumulator creates a new accumulator that calls flush with the buffered
+// items once size items have been added or every interval, whichever comes
+// first. A size or interval of zero disables that trigger.
+func NewAccumulator(size int, interval time.Duration, flush func(items []interface{})) *Accumulator {
+	a := &Accumulator{
+		items: NewConcurrentSlice(),
+		size:  size,
+		flush: flush,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go a.run(interval)
+
+	return a
+}
+
+func (a *Accumulator) run(interval time.Duration) {
+	defer close(a.done)
+	if interval <= 0 {
+		<-a.stop
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.Flush()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Add buffers an item, flushing the buffer if it reached the size threshold.
+// Items added after Close are dropped: they would never be flushed.
+func (a *Accumulator) Add(item interface{}) {
+	a.items.Lock()
+	if a.closed {
+		a.items.Unlock()
+		return
+	}
+	notify := a.items.push(item)
+	n := len(a.items.items)
+	a.items.Unlock()
+	if notify != nil {
+		notify()
+	}
+	if a.size > 0 && n >= a.size {
+		a.Flush()
+	}
+}
+
+// Flush hands all buffered items to the flush function and clears the
+// buffer. Flushes never overlap and are delivered in the order the items
+// were added. The flush function is not called for an empty buffer.
+func (a *Accumulator) Flush() {
+	a.flushLock.Lock()
+	defer a.flushLock.Unlock()
+	a.items.Lock()
+	batch := a.items.items
+	a.items.reset(make([]interface{}, 0))
+	a.items.Unlock()
+	if len(batch) > 0 {
+		a.flush(batch)
+	}
+}
+
+// Close stops the interval timer and flushes any remaining items. Later
+// Adds are dropped.
+func (a *Accumulator) Close() {
+	a.closeOnce.Do(func() {
+		close(a.stop)
+		<-a.done
+		a.items.Lock()
+		a.closed = true
+		a.items.Unlock()
+		a.Flush()
+	})
+}
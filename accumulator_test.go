@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the batches handed to a flush function.
+type recorder struct {
+	lock    sync.Mutex
+	batches [][]interface{}
+}
+
+func (r *recorder) flush(items []interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.batches = append(r.batches, items)
+}
+
+func (r *recorder) get() [][]interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([][]interface{}(nil), r.batches...)
+}
+
+func TestAccumulatorFlushesOnSize(t *testing.T) {
+	var r recorder
+	a := NewAccumulator(2, 0, r.flush)
+	a.Add(1)
+	a.Add(2)
+	a.Add(3)
+	if got, want := r.get(), [][]interface{}{{1, 2}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	a.Close()
+	if got, want := r.get(), [][]interface{}{{1, 2}, {3}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after Close got %v, want %v", got, want)
+	}
+}
+
+func TestAccumulatorFlushesOnTimer(t *testing.T) {
+	var r recorder
+	a := NewAccumulator(0, 10*time.Millisecond, r.flush)
+	defer a.Close()
+	a.Add(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.get()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timer never flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := r.get(), [][]interface{}{{1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestAccumulatorAddAfterClose(t *testing.T) {
+	var r recorder
+	a := NewAccumulator(1, 0, r.flush)
+	a.Add(1)
+	a.Close()
+	a.Add(2)
+	a.Flush()
+	if got, want := r.get(), [][]interface{}{{1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := contents(a.items); len(got) != 0 {
+		t.Fatalf("%v buffered after Close", got)
+	}
+}
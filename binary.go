@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Type tags of the compact binary encoding.
+const (
+	tagNil byte = iota
+	tagBool
+	tagInt
+	tagInt64
+	tagUint64
+	tagFloat64
+	tagString
+	tagBytes
+)
+
+var errShortBuffer = errors.New("utils: binary data is truncated")
+
+// MarshalBinary encodes the items of the concurrent slice in a compact,
+// length-prefixed binary format. Supported item types are nil, bool, int,
+// int64, uint64, float64, string and []byte; any other type is an error.
+func (cs *ConcurrentSlice) MarshalBinary() ([]byte, error) {
+	cs.RLock()
+	defer cs.RUnlock()
+	buf := binary.AppendUvarint(nil, uint64(len(cs.items)))
+	for _, v := range cs.items {
+		var err error
+		if buf, err = appendValue(buf, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary replaces the contents of the concurrent slice with the
+// items decoded from data, as produced by MarshalBinary. The contents are
+// left untouched if data can't be decoded.
+func (cs *ConcurrentSlice) UnmarshalBinary(data []byte) error {
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return err
+	}
+	if n > uint64(len(data)) {
+		return errShortBuffer
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		if items[i], data, err = readValue(data); err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("utils: %d trailing bytes after binary data", len(data))
+	}
+	cs.Lock()
+	defer cs.Unlock()
+	cs.reset(items)
+	return nil
+}
+
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, tagNil), nil
+	case bool:
+		if v {
+			return append(buf, tagBool, 1), nil
+		}
+		return append(buf, tagBool, 0), nil
+	case int:
+		return binary.AppendVarint(append(buf, tagInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(buf, tagInt64), v), nil
+	case uint64:
+		return binary.AppendUvarint(append(buf, tagUint64), v), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(buf, tagFloat64), math.Float64bits(v)), nil
+	case string:
+		buf = binary.AppendUvarint(append(buf, tagString), uint64(len(v)))
+		return append(buf, v...), nil
+	case []byte:
+		buf = binary.AppendUvarint(append(buf, tagBytes), uint64(len(v)))
+		return append(buf, v...), nil
+	}
+	return nil, fmt.Errorf("utils: unsupported type %T for binary encoding", v)
+}
+
+func readValue(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errShortBuffer
+	}
+	tag, data := data[0], data[1:]
+	switch tag {
+	case tagNil:
+		return nil, data, nil
+	case tagBool:
+		if len(data) < 1 {
+			return nil, nil, errShortBuffer
+		}
+		return data[0] != 0, data[1:], nil
+	case tagInt, tagInt64:
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, nil, errShortBuffer
+		}
+		if tag == tagInt {
+			return int(v), data[n:], nil
+		}
+		return v, data[n:], nil
+	case tagUint64:
+		v, data, err := readUvarint(data)
+		return v, data, err
+	case tagFloat64:
+		if len(data) < 8 {
+			return nil, nil, errShortBuffer
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), data[8:], nil
+	case tagString, tagBytes:
+		n, data, err := readUvarint(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > uint64(len(data)) {
+			return nil, nil, errShortBuffer
+		}
+		if tag == tagString {
+			return string(data[:n]), data[n:], nil
+		}
+		b := make([]byte, n)
+		copy(b, data)
+		return b, data[n:], nil
+	}
+	return nil, nil, fmt.Errorf("utils: unknown binary type tag %d", tag)
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errShortBuffer
+	}
+	return v, data[n:], nil
+}
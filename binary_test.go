@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	items := []interface{}{nil, true, -3, int64(math.MinInt64), uint64(math.MaxUint64), 1.5, "text", []byte{1, 2}}
+	data, err := newSlice(items...).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := newSlice("previous")
+	if err := cs.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(cs); !reflect.DeepEqual(got, items) {
+		t.Fatalf("got %#v, want %#v", got, items)
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	if _, err := newSlice(struct{}{}).MarshalBinary(); err == nil {
+		t.Fatal("marshaled an unsupported type")
+	}
+	data, _ := newSlice("text", 1).MarshalBinary()
+	cs := newSlice("kept")
+	for _, bad := range [][]byte{data[:len(data)-1], append(data, 0)} {
+		if err := cs.UnmarshalBinary(bad); err == nil {
+			t.Fatalf("decoded %v", bad)
+		}
+	}
+	if got := contents(cs); !reflect.DeepEqual(got, []interface{}{"kept"}) {
+		t.Fatalf("failed decode changed the contents to %v", got)
+	}
+}
+
+func TestBinarySizeAgainstGob(t *testing.T) {
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = int64(i)
+	}
+	data, err := newSlice(items...).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(items); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("1000 int64s: binary %d bytes, gob %d bytes", len(data), buf.Len())
+	if len(data) >= buf.Len() {
+		t.Errorf("binary encoding is not smaller than gob")
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import "sync"
+
+// BufferedSlice is a concurrent slice whose appends are handed to a
+// background goroutine, which applies them to the underlying slice in
+// batches to cut down lock acquisitions under heavy append rates.
+// Appended items become visible to readers once their batch is applied;
+// call Flush to wait for that.
+type BufferedSlice struct {
+	*ConcurrentSlice
+	flushEvery int
+	in         chan interface{}
+	done       chan struct{}
+	// closeLock guards closed and closing in.
+	closeLock sync.RWMutex
+	closed    bool
+}
+
+type bufferedFlush struct {
+	done chan struct{}
+}
+
+// NewBufferedSlice creates a new buffered slice applying appends in batches
+// of up to flushEvery items.
+func NewBufferedSlice(flushEvery int) *BufferedSlice {
+	if flushEvery < 1 {
+		flushEvery = 1
+	}
+	bs := &BufferedSlice{
+		ConcurrentSlice: NewConcurrentSlice(),
+		flushEvery:      flushEvery,
+		in:              make(chan interface{}, flushEvery),
+		done:            make(chan struct{}),
+	}
+	go bs.run()
+
+	return bs
+}
+
+func (bs *BufferedSlice) run() {
+	defer close(bs.done)
+	batch := make([]interface{}, 0, bs.flushEvery)
+	apply := func() {
+		if len(batch) == 0 {
+			return
+		}
+		bs.Lock()
+		notify := bs.push(batch...)
+		bs.Unlock()
+		batch = batch[:0]
+		if notify != nil {
+			notify()
+		}
+	}
+	for v := range bs.in {
+		if f, ok := v.(bufferedFlush); ok {
+			apply()
+			close(f.done)
+			continue
+		}
+		batch = append(batch, v)
+		// Apply once the batch is full or nothing else is queued, so items
+		// don't linger when the append rate drops.
+		if len(batch) >= bs.flushEvery || len(bs.in) == 0 {
+			apply()
+		}
+	}
+	apply()
+}
+
+// Append queues an item to be added to the slice. It must not be called
+// after Close.
+func (bs *BufferedSlice) Append(item interface{}) {
+	bs.in <- item
+}
+
+// Flush waits until every item appended before the call has been applied
+// to the slice. After Close every item is applied already, and Flush
+// returns at once.
+func (bs *BufferedSlice) Flush() {
+	bs.closeLock.RLock()
+	if bs.closed {
+		bs.closeLock.RUnlock()
+		return
+	}
+	done := make(chan struct{})
+	bs.in <- bufferedFlush{done}
+	bs.closeLock.RUnlock()
+	<-done
+}
+
+// Close applies all pending items and stops the background goroutine.
+func (bs *BufferedSlice) Close() {
+	bs.closeLock.Lock()
+	if !bs.closed {
+		bs.closed = true
+		close(bs.in)
+	}
+	bs.closeLock.Unlock()
+	<-bs.done
+}
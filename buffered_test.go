@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestBufferedSliceCloseFlushes(t *testing.T) {
+	bs := NewBufferedSlice(64)
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				bs.Append(i)
+			}
+		}()
+	}
+	wait.Wait()
+	bs.Close()
+	if n := len(contents(bs.ConcurrentSlice)); n != 4000 {
+		t.Fatalf("got %d items after Close, want 4000", n)
+	}
+}
+
+func TestBufferedSliceFlush(t *testing.T) {
+	bs := NewBufferedSlice(1024)
+	defer bs.Close()
+	for i := 0; i < 10; i++ {
+		bs.Append(i)
+	}
+	bs.Flush()
+	got := contents(bs.ConcurrentSlice)
+	if len(got) != 10 {
+		t.Fatalf("got %d items after Flush, want 10", len(got))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("item %d is %v, appends reordered", i, v)
+		}
+	}
+}
+
+// BenchmarkAppendParallel appends from GOMAXPROCS*8 goroutines to a plain
+// ConcurrentSlice and to BufferedSlices.
+func BenchmarkAppendParallel(b *testing.B) {
+	b.Run("ConcurrentSlice", func(b *testing.B) {
+		b.ReportAllocs()
+		cs := NewConcurrentSlice()
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				cs.Append(1)
+			}
+		})
+	})
+	for _, flushEvery := range []int{64, 1024} {
+		b.Run("BufferedSlice"+strconv.Itoa(flushEvery), func(b *testing.B) {
+			b.ReportAllocs()
+			bs := NewBufferedSlice(flushEvery)
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bs.Append(1)
+				}
+			})
+			bs.Close()
+		})
+	}
+}
+
+func TestBufferedSliceFlushAfterClose(t *testing.T) {
+	bs := NewBufferedSlice(64)
+	bs.Append(1)
+	bs.Close()
+	bs.Flush()
+	bs.Close()
+	if got := contents(bs.ConcurrentSlice); len(got) != 1 {
+		t.Fatalf("got %v after Close", got)
+	}
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Codec serializes the contents of a map for persistence.
+type Codec interface {
+	Encode(w io.Writer, m map[string]interface{}) error
+	Decode(r io.Reader) (map[string]interface{}, error)
+}
+
+// GobCodec encodes maps with encoding/gob. Value types other than the
+// builtin ones must be registered with gob.Register.
+type GobCodec struct{}
+
+// Encode writes m to w.
+func (GobCodec) Encode(w io.Writer, m map[string]interface{}) error {
+	return gob.NewEncoder(w).Encode(m)
+}
+
+// Decode reads a map from r.
+func (GobCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := gob.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// JSONCodec encodes maps with encoding/json. Values come back as the types
+// encoding/json decodes into, e.g. all numbers as float64.
+type JSONCodec struct{}
+
+// Encode writes m to w.
+func (JSONCodec) Encode(w io.Writer, m map[string]interface{}) error {
+	return json.NewEncoder(w).Encode(m)
+}
+
+// Decode reads a map from r.
+func (JSONCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BinaryCodec encodes maps in the compact binary format used by
+// ConcurrentSlice.MarshalBinary, and supports the same value types.
+type BinaryCodec struct{}
+
+// Encode writes m to w.
+func (BinaryCodec) Encode(w io.Writer, m map[string]interface{}) error {
+	buf := binary.AppendUvarint(nil, uint64(len(m)))
+	for k, v := range m {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		var err error
+		if buf, err = appendValue(buf, v); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Decode reads a map from r.
+func (BinaryCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(data)) {
+		return nil, errShortBuffer
+	}
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		var keyLen uint64
+		if keyLen, data, err = readUvarint(data); err != nil {
+			return nil, err
+		}
+		if keyLen > uint64(len(data)) {
+			return nil, errShortBuffer
+		}
+		key := string(data[:keyLen])
+		if m[key], data, err = readValue(data[keyLen:]); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("utils: %d trailing bytes after binary data", len(data))
+	}
+	return m, nil
+}
+
+// SaveToFile writes a snapshot of the concurrent map to the file at path
+// using codec. The snapshot is written to a temporary file in the same
+// directory which then replaces path, so a failed save leaves any previous
+// file intact.
+func (cm *ConcurrentMap) SaveToFile(path string, codec Codec) error {
+	cm.RLock()
+	snapshot := make(map[string]interface{}, len(cm.items))
+	for k, v := range cm.items {
+		snapshot[k] = v
+	}
+	cm.RUnlock()
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = codec.Encode(f, snapshot)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// LoadFromFile replaces the contents of the concurrent map with the map
+// decoded by codec from the file at path. The contents are left untouched
+// if the file can't be read or decoded.
+func (cm *ConcurrentMap) LoadFromFile(path string, codec Codec) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	items, err := codec.Decode(f)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		items = make(map[string]interface{})
+	}
+	cm.Lock()
+	defer cm.Unlock()
+	cm.items = items
+	return nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	// Values of types every codec decodes back as is.
+	want := map[string]interface{}{"s": "text", "f": 1.5, "b": true}
+	path := filepath.Join(t.TempDir(), "map")
+	for _, codec := range []Codec{GobCodec{}, JSONCodec{}, BinaryCodec{}} {
+		cm := NewConcurrentMap()
+		for k, v := range want {
+			cm.Set(k, v)
+		}
+		if err := cm.SaveToFile(path, codec); err != nil {
+			t.Fatalf("%T: %v", codec, err)
+		}
+		loaded := NewConcurrentMap()
+		loaded.Set("stale", 1)
+		if err := loaded.LoadFromFile(path, codec); err != nil {
+			t.Fatalf("%T: %v", codec, err)
+		}
+		if !reflect.DeepEqual(loaded.items, want) {
+			t.Fatalf("%T: got %v, want %v", codec, loaded.items, want)
+		}
+	}
+}
+
+type failingCodec struct{ BinaryCodec }
+
+func (failingCodec) Encode(w io.Writer, m map[string]interface{}) error {
+	w.Write([]byte("partial"))
+	return errors.New("encode failed")
+}
+
+func TestSaveToFileFailureKeepsOldFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "map")
+	cm := NewConcurrentMap()
+	cm.Set("k", "v")
+	if err := cm.SaveToFile(path, BinaryCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := os.ReadFile(path)
+	if err := cm.SaveToFile(path, failingCodec{}); err == nil {
+		t.Fatal("failed encode reported no error")
+	}
+	if got, _ := os.ReadFile(path); !reflect.DeepEqual(got, old) {
+		t.Fatal("failed save changed the file")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("failed save left %d files behind", len(entries)-1)
+	}
+}
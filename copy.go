@@ -0,0 +1,77 @@
+package utils
+
+import "reflect"
+
+// DeepCopy returns a deep copy of slices and maps, recursing into their
+// elements, and of pointers, which get a new copy of the value they point
+// to. References shared within v, cycles included, are copied once and stay
+// shared in the copy. Pointers to structs are returned as is, since copying
+// a struct would copy any lock it holds without taking it. Any other value,
+// including structs and arrays holding references, is returned as is.
+func DeepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(v), map[copyRef]reflect.Value{}).Interface()
+}
+
+// copyRef identifies a pointer, slice or map already copied by DeepCopy.
+type copyRef struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func deepCopy(v reflect.Value, copied map[copyRef]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Ptr:
+		if v.IsNil() || v.Type().Elem().Kind() == reflect.Struct {
+			return v
+		}
+		ref := copyRef{v.Pointer(), v.Type(), 0}
+		if c, ok := copied[ref]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		copied[ref] = c
+		c.Elem().Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ref := copyRef{v.Pointer(), v.Type(), v.Len()}
+		if c, ok := copied[ref]; ok {
+			return c
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		copied[ref] = c
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		ref := copyRef{v.Pointer(), v.Type(), 0}
+		if c, ok := copied[ref]; ok {
+			return c
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		copied[ref] = c
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value(), copied))
+		}
+		return c
+	}
+	return v
+}
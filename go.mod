@@ -0,0 +1,3 @@
+module github.com/maurodelazeri/concurrency-map-slice
+
+go 1.18
@@ -0,0 +1,29 @@
+package utils
+
+import "sync"
+
+// LazyIterator is an iterator over a concurrent slice that does nothing
+// until started: creating one neither locks the slice nor starts a
+// goroutine, so it costs nothing on paths that end up not iterating.
+type LazyIterator struct {
+	cs      *ConcurrentSlice
+	bufSize int
+	once    sync.Once
+	c       <-chan ConcurrentSliceItem
+}
+
+// IterLazy returns an iterator that behaves like IterBuffered(bufSize), but
+// only snapshots the concurrent slice when Start is first called.
+func (cs *ConcurrentSlice) IterLazy(bufSize int) *LazyIterator {
+	return &LazyIterator{cs: cs, bufSize: bufSize}
+}
+
+// Start snapshots the concurrent slice and starts sending its items on the
+// first call, and returns the channel to range over. Later calls return the
+// same channel.
+func (it *LazyIterator) Start() <-chan ConcurrentSliceItem {
+	it.once.Do(func() {
+		it.c = it.cs.IterBuffered(it.bufSize)
+	})
+	return it.c
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestIterLazy(t *testing.T) {
+	cs := newSlice(1, 2)
+	before := runtime.NumGoroutine()
+	// Creating the iterator must neither lock the slice nor start a
+	// goroutine: hold the write lock while doing it.
+	cs.Lock()
+	it := cs.IterLazy(0)
+	cs.Unlock()
+	if n := runtime.NumGoroutine(); n != before {
+		t.Fatalf("%d goroutines before Start, want %d", n, before)
+	}
+	// The snapshot is taken on Start, so it sees this append.
+	cs.Append(3)
+	c := it.Start()
+	if it.Start() != c {
+		t.Fatal("second Start returned another channel")
+	}
+	var got []interface{}
+	for item := range c {
+		got = append(got, item.Value)
+	}
+	if len(got) != 3 || got[2] != 3 {
+		t.Fatalf("got %v, want [1 2 3]", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+//////////////////////////////// BATCHED GO ROUTINE BASED MAP ////////////////////////////////
+
+type batchOp struct {
+	get   bool
+	key   string
+	value interface{}
+}
+
+type mapBatch struct {
+	ops []batchOp
+	out chan []mapResult
+}
+
+// GoMapBatched is a GoMap that receives operations in batches, amortizing
+// the cost of a channel handoff over many operations. Producers buffer
+// operations in their own MapBatcher. The tradeoff is latency: buffered
+// Sets are not visible to other goroutines until their batch is flushed.
+type GoMapBatched struct {
+	in   chan mapBatch
+	done chan struct{}
+	m    map[string]interface{}
+}
+
+func NewGoMapBatched() *GoMapBatched {
+	g := &GoMapBatched{in: make(chan mapBatch), done: make(chan struct{}), m: make(map[string]interface{})}
+	go g.run()
+	return g
+}
+
+func (g *GoMapBatched) run() {
+	defer close(g.done)
+	for b := range g.in {
+		var results []mapResult
+		for _, op := range b.ops {
+			if op.get {
+				value, ok := g.m[op.key]
+				results = append(results, mapResult{value, ok})
+			} else {
+				g.m[op.key] = op.value
+			}
+		}
+		if b.out != nil {
+			b.out <- results
+		}
+	}
+}
+
+func (g *GoMapBatched) Stop() {
+	close(g.in)
+	<-g.done
+}
+
+func (g *GoMapBatched) Get(key string) (interface{}, bool) {
+	c := make(chan []mapResult)
+	g.in <- mapBatch{[]batchOp{{get: true, key: key}}, c}
+	r := (<-c)[0]
+	return r.value, r.ok
+}
+
+func (g *GoMapBatched) Set(key string, value interface{}) {
+	g.in <- mapBatch{[]batchOp{{key: key, value: value}}, nil}
+}
+
+// Batcher returns a new MapBatcher flushing every size buffered Sets. Each
+// producer goroutine should use its own MapBatcher.
+func (g *GoMapBatched) Batcher(size int) *MapBatcher {
+	return &MapBatcher{g: g, size: size}
+}
+
+// MapBatcher buffers operations for a GoMapBatched. It is not safe for
+// concurrent use.
+type MapBatcher struct {
+	g    *GoMapBatched
+	ops  []batchOp
+	size int
+}
+
+// Set buffers a Set, flushing the buffer once it holds size operations.
+func (b *MapBatcher) Set(key string, value interface{}) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	if len(b.ops) >= b.size {
+		b.Flush()
+	}
+}
+
+// Get sends the buffered operations along with the read in one batch, so
+// the result reflects this batcher's own earlier Sets.
+func (b *MapBatcher) Get(key string) (interface{}, bool) {
+	c := make(chan []mapResult)
+	b.g.in <- mapBatch{append(b.ops, batchOp{get: true, key: key}), c}
+	b.ops = nil
+	results := <-c
+	r := results[len(results)-1]
+	return r.value, r.ok
+}
+
+// Flush sends the buffered operations to the map.
+func (b *MapBatcher) Flush() {
+	if len(b.ops) == 0 {
+		return
+	}
+	b.g.in <- mapBatch{b.ops, nil}
+	b.ops = nil
+}
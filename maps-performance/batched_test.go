@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMapBatcher(t *testing.T) {
+	g := NewGoMapBatched()
+	defer g.Stop()
+	b := g.Batcher(4)
+	b.Set("a", 1)
+	b.Set("b", 2)
+	if _, ok := g.Get("a"); ok {
+		t.Fatal("buffered Set visible before the batch was sent")
+	}
+	// A batcher's own Gets see its buffered Sets.
+	if v, _ := b.Get("b"); v != 2 {
+		t.Fatalf("batcher Get = %v, want 2", v)
+	}
+	if v, _ := g.Get("a"); v != 1 {
+		t.Fatalf("Get after the batch = %v, want 1", v)
+	}
+	for i := 0; i < 4; i++ {
+		b.Set(strconv.Itoa(i), i)
+	}
+	if v, _ := g.Get("3"); v != 3 {
+		t.Fatalf("full batch not flushed: got %v", v)
+	}
+	b.Set("last", 5)
+	b.Flush()
+	if v, _ := g.Get("last"); v != 5 {
+		t.Fatalf("Flush didn't send the batch: got %v", v)
+	}
+}
+
+func BenchmarkBatched(b *testing.B) {
+	const goroutines, burst = 32, 256
+	b.Run("GoMap", func(b *testing.B) {
+		g := NewGoMap()
+		defer g.Stop()
+		for i := 0; i < b.N; i++ {
+			TestBurstsInParallel(g, goroutines, burst, int64(i))
+		}
+	})
+	for _, size := range []int{1, 16, 64, 256} {
+		b.Run("GoMapBatched"+strconv.Itoa(size), func(b *testing.B) {
+			g := NewGoMapBatched()
+			defer g.Stop()
+			for i := 0; i < b.N; i++ {
+				TestBatchedInParallel(g, goroutines, burst, size, int64(i))
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////// BOUNDED SYNC MAP //////////////////////////////////
+
+// BoundPolicy decides what a BoundedSyncMap does when a new key is set while
+// it is full.
+type BoundPolicy int
+
+const (
+	// BoundReject refuses the new key.
+	BoundReject BoundPolicy = iota
+	// BoundEvictRandom drops an entry chosen uniformly at random to make room
+	// for the new key.
+	BoundEvictRandom
+)
+
+// BoundedSyncMap is a SyncMap holding at most max entries. It keeps no
+// recency information, use it when strict LRU eviction isn't needed.
+type BoundedSyncMap struct {
+	lock   sync.RWMutex
+	m      map[string]interface{}
+	max    int
+	policy BoundPolicy
+	// evict tracks the keys for BoundEvictRandom, it is nil otherwise.
+	evict EvictionPolicy
+}
+
+func NewBoundedSyncMap(max int, policy BoundPolicy) *BoundedSyncMap {
+	b := &BoundedSyncMap{m: make(map[string]interface{}, max), max: max, policy: policy}
+	if policy == BoundEvictRandom {
+		b.evict = NewRandomPolicy(rand.New(rand.NewSource(time.Now().UnixNano())))
+	}
+	return b
+}
+
+func (b *BoundedSyncMap) Get(key string) (interface{}, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	value, ok := b.m[key]
+	return value, ok
+}
+
+// Set stores value at key and reports whether it did. Updating an existing
+// key always succeeds; a new key is refused when the map is full and the
+// policy is BoundReject.
+func (b *BoundedSyncMap) Set(key string, value interface{}) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.m[key]; ok {
+		b.m[key] = value
+		return true
+	}
+	if len(b.m) >= b.max {
+		if b.evict == nil || b.max <= 0 {
+			return false
+		}
+		delete(b.m, b.evict.Evict())
+	}
+	b.m[key] = value
+	if b.evict != nil {
+		b.evict.RecordInsert(key)
+	}
+	return true
+}
+
+func (b *BoundedSyncMap) Delete(key string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.m[key]; ok && b.evict != nil {
+		b.evict.RecordRemove(key)
+	}
+	delete(b.m, key)
+}
+
+func (b *BoundedSyncMap) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return len(b.m)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestBoundedSyncMapReject(t *testing.T) {
+	b := NewBoundedSyncMap(2, BoundReject)
+	if !b.Set("a", 1) || !b.Set("b", 2) {
+		t.Fatal("refused a key below the bound")
+	}
+	if b.Set("c", 3) {
+		t.Fatal("accepted a key past the bound")
+	}
+	if !b.Set("a", 10) {
+		t.Fatal("refused updating an existing key")
+	}
+	b.Delete("b")
+	if !b.Set("c", 3) || b.Len() != 2 {
+		t.Fatalf("refused a key after a delete, Len %d", b.Len())
+	}
+}
+
+func TestBoundedSyncMapEvict(t *testing.T) {
+	b := NewBoundedSyncMap(10, BoundEvictRandom)
+	for i := 0; i < 100; i++ {
+		if !b.Set(strconv.Itoa(i), i) {
+			t.Fatalf("evicting map refused key %d", i)
+		}
+		if i%7 == 0 {
+			b.Delete(strconv.Itoa(i))
+		}
+		if b.Len() > 10 {
+			t.Fatalf("Len %d past the bound", b.Len())
+		}
+	}
+	if b.Len() != 10 {
+		t.Fatalf("Len %d, want 10", b.Len())
+	}
+}
+
+func TestBoundedSyncMapEvictUniform(t *testing.T) {
+	const trials = 4000
+	keys := []string{"a", "b", "c", "d"}
+	evicted := make(map[string]int)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < trials; i++ {
+		b := NewBoundedSyncMap(len(keys), BoundEvictRandom)
+		b.evict = NewRandomPolicy(rnd)
+		for _, k := range keys {
+			b.Set(k, nil)
+		}
+		b.Set("new", nil)
+		for _, k := range keys {
+			if _, ok := b.Get(k); !ok {
+				evicted[k]++
+			}
+		}
+	}
+	for _, k := range keys {
+		if n := evicted[k]; n < trials/len(keys)*8/10 || n > trials/len(keys)*12/10 {
+			t.Errorf("%s evicted %d times out of %d", k, n, trials)
+		}
+	}
+}
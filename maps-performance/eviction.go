@@ -0,0 +1,207 @@
+package main
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+)
+
+//////////////////////////////////// BOUNDED MAP //////////////////////////////////
+
+// EvictionPolicy picks the key a BoundedMap drops when a new key is set
+// while it is full. The map calls it with its lock held, so implementations
+// need no locking of their own.
+type EvictionPolicy interface {
+	// RecordAccess is called when an existing key is read or updated.
+	RecordAccess(key string)
+	// RecordInsert is called when a new key is added.
+	RecordInsert(key string)
+	// RecordRemove is called when a key is deleted from the map.
+	RecordRemove(key string)
+	// Evict chooses a key to drop and forgets it.
+	Evict() string
+}
+
+// BoundedMap is a map holding at most max entries, like BoundedSyncMap, but
+// delegating the choice of the entry to drop when full to an EvictionPolicy.
+type BoundedMap struct {
+	lock   sync.Mutex
+	m      map[string]interface{}
+	max    int
+	policy EvictionPolicy
+}
+
+func NewBoundedMap(max int, policy EvictionPolicy) *BoundedMap {
+	return &BoundedMap{m: make(map[string]interface{}, max), max: max, policy: policy}
+}
+
+// Get takes the write lock, since reads update the policy.
+func (b *BoundedMap) Get(key string) (interface{}, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	value, ok := b.m[key]
+	if ok {
+		b.policy.RecordAccess(key)
+	}
+	return value, ok
+}
+
+// Set stores value at key, evicting the entry chosen by the policy if key is
+// new and the map is full.
+func (b *BoundedMap) Set(key string, value interface{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.m[key]; ok {
+		b.m[key] = value
+		b.policy.RecordAccess(key)
+		return
+	}
+	if b.max <= 0 {
+		return
+	}
+	if len(b.m) >= b.max {
+		delete(b.m, b.policy.Evict())
+	}
+	b.m[key] = value
+	b.policy.RecordInsert(key)
+}
+
+func (b *BoundedMap) Delete(key string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.m[key]; ok {
+		delete(b.m, key)
+		b.policy.RecordRemove(key)
+	}
+}
+
+func (b *BoundedMap) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.m)
+}
+
+// orderPolicy evicts the key at the front of a list, moving keys to the back
+// on insert and, if touch is set, on access.
+type orderPolicy struct {
+	order *list.List
+	elems map[string]*list.Element
+	touch bool
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used key.
+func NewLRUPolicy() EvictionPolicy {
+	return &orderPolicy{order: list.New(), elems: make(map[string]*list.Element), touch: true}
+}
+
+// NewFIFOPolicy returns a policy evicting the oldest inserted key, whatever
+// the accesses since.
+func NewFIFOPolicy() EvictionPolicy {
+	return &orderPolicy{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *orderPolicy) RecordAccess(key string) {
+	if e, ok := p.elems[key]; ok && p.touch {
+		p.order.MoveToBack(e)
+	}
+}
+
+func (p *orderPolicy) RecordInsert(key string) {
+	p.elems[key] = p.order.PushBack(key)
+}
+
+func (p *orderPolicy) RecordRemove(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.order.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *orderPolicy) Evict() string {
+	key := p.order.Remove(p.order.Front()).(string)
+	delete(p.elems, key)
+	return key
+}
+
+type lfuEntry struct {
+	count uint64
+	seq   uint64
+}
+
+// lfuPolicy counts the accesses to each key. Evict scans all the keys, so it
+// is O(n).
+type lfuPolicy struct {
+	entries map[string]*lfuEntry
+	seq     uint64
+}
+
+// NewLFUPolicy returns a policy evicting the least frequently used key, the
+// oldest inserted one among equally used keys.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) RecordAccess(key string) {
+	if e, ok := p.entries[key]; ok {
+		e.count++
+	}
+}
+
+func (p *lfuPolicy) RecordInsert(key string) {
+	p.seq++
+	p.entries[key] = &lfuEntry{seq: p.seq}
+}
+
+func (p *lfuPolicy) RecordRemove(key string) {
+	delete(p.entries, key)
+}
+
+func (p *lfuPolicy) Evict() string {
+	var victim string
+	var min *lfuEntry
+	for key, e := range p.entries {
+		if min == nil || e.count < min.count || (e.count == min.count && e.seq < min.seq) {
+			victim, min = key, e
+		}
+	}
+	delete(p.entries, victim)
+	return victim
+}
+
+// randomPolicy keeps the keys in a slice so one can be drawn uniformly.
+type randomPolicy struct {
+	keys  []string
+	index map[string]int
+	rnd   *rand.Rand
+}
+
+// NewRandomPolicy returns a policy evicting a key chosen uniformly at random
+// using rnd, so a seeded source gives reproducible evictions.
+func NewRandomPolicy(rnd *rand.Rand) EvictionPolicy {
+	return &randomPolicy{index: make(map[string]int), rnd: rnd}
+}
+
+func (p *randomPolicy) RecordAccess(key string) {}
+
+func (p *randomPolicy) RecordInsert(key string) {
+	p.index[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+}
+
+func (p *randomPolicy) RecordRemove(key string) {
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	last := len(p.keys) - 1
+	p.keys[i] = p.keys[last]
+	p.index[p.keys[i]] = i
+	p.keys = p.keys[:last]
+	delete(p.index, key)
+}
+
+func (p *randomPolicy) Evict() string {
+	key := p.keys[p.rnd.Intn(len(p.keys))]
+	p.RecordRemove(key)
+	return key
+}
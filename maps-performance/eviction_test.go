@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		victim string
+	}{
+		{"LRU", NewLRUPolicy(), "c"},
+		{"LFU", NewLFUPolicy(), "b"},
+		{"FIFO", NewFIFOPolicy(), "a"},
+	}
+	for _, tt := range tests {
+		b := NewBoundedMap(3, tt.policy)
+		b.Set("a", 1)
+		b.Set("b", 2)
+		b.Set("c", 3)
+		// c is used most but longest ago, b least but most recently.
+		for _, k := range []string{"c", "c", "c", "a", "a", "b"} {
+			b.Get(k)
+		}
+		b.Set("d", 4)
+		if b.Len() != 3 {
+			t.Fatalf("%s: Len %d, want 3", tt.name, b.Len())
+		}
+		for _, k := range []string{"a", "b", "c", "d"} {
+			if _, ok := b.Get(k); ok == (k == tt.victim) {
+				t.Errorf("%s: %s present %v, want %s evicted", tt.name, k, ok, tt.victim)
+			}
+		}
+	}
+}
+
+func TestRandomPolicyReproducible(t *testing.T) {
+	evicted := func(seed int64) []string {
+		b := NewBoundedMap(3, NewRandomPolicy(rand.New(rand.NewSource(seed))))
+		var gone []string
+		for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+			b.Set(k, nil)
+		}
+		for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+			if _, ok := b.Get(k); !ok {
+				gone = append(gone, k)
+			}
+		}
+		return gone
+	}
+	a, b := evicted(7), evicted(7)
+	if len(a) != 3 || len(b) != 3 {
+		t.Fatalf("evicted %v and %v, want 3 keys each", a, b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed evicted %v and %v", a, b)
+		}
+	}
+}
+
+func TestBoundedMapDeleteForgetsKey(t *testing.T) {
+	b := NewBoundedMap(2, NewFIFOPolicy())
+	b.Set("a", 1)
+	b.Set("b", 2)
+	b.Delete("a")
+	b.Set("c", 3)
+	b.Set("d", 4)
+	// a is gone from the policy too, so b is next in line, not a.
+	if _, ok := b.Get("b"); ok {
+		t.Fatal("b not evicted")
+	}
+	if _, ok := b.Get("c"); !ok {
+		t.Fatal("c evicted instead of b")
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// NewMap builds a map implementation by name, so the backend can be picked
+// from configuration. The channel based maps it returns still need to be
+// stopped by the caller.
+func NewMap(kind string) (Map, error) {
+	switch kind {
+	case "gomap":
+		return NewGoMap(), nil
+	case "gomap1chan":
+		return NewGoMap1Chan(), nil
+	case "syncmap":
+		return NewSyncMap(), nil
+	case "orderedsyncmap":
+		return NewOrderedSyncMap(), nil
+	}
+	return nil, fmt.Errorf("unknown map kind %q", kind)
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestNewMap(t *testing.T) {
+	for _, kind := range []string{"gomap", "gomap1chan", "syncmap", "orderedsyncmap"} {
+		m, err := NewMap(kind)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		m.Set("k", 1)
+		if v, ok := m.Get("k"); v != 1 || !ok {
+			t.Errorf("%s: got %v, %v, want 1, true", kind, v, ok)
+		}
+		if s, ok := m.(interface{ Stop() }); ok {
+			s.Stop()
+		}
+	}
+	if m, err := NewMap("shardedmap"); err == nil {
+		t.Fatalf("unknown kind built %T", m)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "sync"
+
+//////////////////////////////////// IN-FLIGHT CALL DEDUPLICATION //////////////////////////////////
+
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	// panicked reports whether the call panicked, with panicValue.
+	panicked   bool
+	panicValue interface{}
+}
+
+// result returns the outcome of a finished call. If the call panicked, the
+// panic is raised again in every caller asking for the result, rather than
+// crashing the process from the goroutine running the call.
+func (c *flightCall) result() (interface{}, error) {
+	if c.panicked {
+		panic(c.panicValue)
+	}
+	return c.value, c.err
+}
+
+// flightGroup makes sure only one call per key is running at a time, callers
+// asking for a key that is already being computed share its result.
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+func (f *flightGroup) do(key string, fn func() (interface{}, error)) *flightCall {
+	f.lock.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.lock.Unlock()
+		return c
+	}
+	if f.calls == nil {
+		f.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	f.calls[key] = c
+	f.lock.Unlock()
+
+	go func() {
+		c.panicked = true
+		defer func() {
+			if c.panicked {
+				c.panicValue = recover()
+			}
+			f.lock.Lock()
+			delete(f.calls, key)
+			f.lock.Unlock()
+			close(c.done)
+		}()
+		c.value, c.err = fn()
+		c.panicked = false
+	}()
+	return c
+}
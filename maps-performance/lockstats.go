@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//////////////////////////////////// LOCK CONTENTION STATS //////////////////////////////////
+
+// lockStats counts the lock acquisitions that had to wait and the total time
+// spent waiting.
+type lockStats struct {
+	waits     uint64
+	totalWait int64
+}
+
+// statsRWMutex is a sync.RWMutex that records contention in stats when it is
+// set. With nil stats it costs a single nil check per acquisition.
+type statsRWMutex struct {
+	sync.RWMutex
+	stats *lockStats
+}
+
+func (l *statsRWMutex) Lock() {
+	if l.stats == nil {
+		l.RWMutex.Lock()
+		return
+	}
+	if l.RWMutex.TryLock() {
+		return
+	}
+	start := time.Now()
+	l.RWMutex.Lock()
+	l.stats.record(time.Since(start))
+}
+
+func (l *statsRWMutex) RLock() {
+	if l.stats == nil {
+		l.RWMutex.RLock()
+		return
+	}
+	if l.RWMutex.TryRLock() {
+		return
+	}
+	start := time.Now()
+	l.RWMutex.RLock()
+	l.stats.record(time.Since(start))
+}
+
+func (s *lockStats) record(wait time.Duration) {
+	atomic.AddUint64(&s.waits, 1)
+	atomic.AddInt64(&s.totalWait, int64(wait))
+}
+
+// NewSyncMapInstrumented returns a SyncMap that records lock contention, see
+// LockStats.
+func NewSyncMapInstrumented() *SyncMap {
+	s := NewSyncMap()
+	s.lock.stats = &lockStats{}
+	return s
+}
+
+// LockStats returns the number of lock acquisitions that had to wait because
+// the lock was held and the total time spent waiting. Both are zero unless
+// the map was created with NewSyncMapInstrumented.
+func (s *SyncMap) LockStats() (waits uint64, totalWait time.Duration) {
+	if s.lock.stats == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&s.lock.stats.waits), time.Duration(atomic.LoadInt64(&s.lock.stats.totalWait))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// contend makes a Get and a Set wait for s's lock.
+func contend(s *SyncMap) {
+	s.lock.Lock()
+	done := make(chan struct{})
+	go func() {
+		s.Get("k")
+		s.Set("k", 1)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.lock.Unlock()
+	<-done
+}
+
+func TestLockStats(t *testing.T) {
+	s := NewSyncMapInstrumented()
+	if waits, total := s.LockStats(); waits != 0 || total != 0 {
+		t.Fatalf("fresh map has stats %d, %v", waits, total)
+	}
+	s.Set("k", 0)
+	if waits, _ := s.LockStats(); waits != 0 {
+		t.Fatalf("uncontended Set counted %d waits", waits)
+	}
+	contend(s)
+	waits, total := s.LockStats()
+	if waits == 0 || total < 5*time.Millisecond {
+		t.Fatalf("got %d waits, %v, want some waiting", waits, total)
+	}
+}
+
+func TestLockStatsDisabled(t *testing.T) {
+	s := NewSyncMap()
+	contend(s)
+	if waits, total := s.LockStats(); waits != 0 || total != 0 {
+		t.Fatalf("uninstrumented map has stats %d, %v", waits, total)
+	}
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
 	"math/rand"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"testing"
 	"time"
+
+	utils "github.com/maurodelazeri/concurrency-map-slice"
 )
 
 type Map interface {
@@ -14,6 +22,11 @@ type Map interface {
 	Set(key string, value interface{})
 }
 
+type MapEntry struct {
+	Key   string
+	Value interface{}
+}
+
 ///////////////////////////////// GO ROUTINE BASED MAP ////////////////////////////////////////
 
 type mapResult struct {
@@ -28,27 +41,143 @@ type mapSet struct {
 	key   string
 	value interface{}
 }
+type mapGetOrSetResult struct {
+	value  interface{}
+	loaded bool
+	err    error
+}
+type mapGetOrSet struct {
+	key     string
+	fn      func() interface{}
+	timeout time.Duration
+	out     chan mapGetOrSetResult
+}
+type mapLoadAll struct {
+	out chan []MapEntry
+}
+type mapIncrementResult struct {
+	value      int64
+	overflowed bool
+	err        error
+}
+type mapIncrement struct {
+	key   string
+	delta int64
+	out   chan mapIncrementResult
+}
+type mapContainsValue struct {
+	value interface{}
+	out   chan bool
+}
+type mapMutate struct {
+	key     string
+	fn      func(old interface{}, ok bool) (new interface{}, store bool)
+	timeout time.Duration
+	// out receives the outcome, it is nil for a fire-and-forget Mutate.
+	out chan error
+}
+type mapLoadAndDelete struct {
+	key string
+	out chan mapResult
+}
+type mapClaimPrefix struct {
+	prefix string
+	limit  int
+	out    chan []MapEntry
+}
+type mapSetMulti struct {
+	entries []MapEntry
+}
+type mapGetMulti struct {
+	keys []string
+	out  chan map[string]interface{}
+}
+type mapDeleteMulti struct {
+	keys []string
+}
+type mapSnapshot struct {
+	out chan map[string]interface{}
+}
+type mapSetIfNewer struct {
+	key       string
+	value     interface{}
+	timestamp int64
+	out       chan bool
+}
+type mapAcquire struct {
+	acquired chan struct{}
+	release  chan []mapChange
+}
+type mapSetAndReport struct {
+	key   string
+	value interface{}
+	out   chan bool
+}
+type mapOnChange struct {
+	fn func(op string, key string, value interface{})
+}
 
 type GoMap struct {
-	get  chan mapGet
-	set  chan mapSet
-	done chan bool
-	m    map[string]interface{}
+	get      chan mapGet
+	set      chan mapSet
+	getOrSet chan mapGetOrSet
+	loadAll  chan mapLoadAll
+	incr     chan mapIncrement
+	contains chan mapContainsValue
+	mutate   chan mapMutate
+	claim    chan mapClaimPrefix
+	loadDel  chan mapLoadAndDelete
+	setMulti chan mapSetMulti
+	getMulti chan mapGetMulti
+	delMulti chan mapDeleteMulti
+	snapshot chan mapSnapshot
+	newer    chan mapSetIfNewer
+	report   chan mapSetAndReport
+	acquire  chan mapAcquire
+	onChange chan mapOnChange
+	done     chan struct{}
+	m        map[string]interface{}
+	stamps   map[string]int64
+	ownerHooks
 }
 
 func NewGoMap() *GoMap {
+	return NewGoMapSize(0)
+}
+
+func NewGoMapSize(n int) *GoMap {
+	return newGoMap(make(map[string]interface{}, n), make(map[string]int64))
+}
+
+func newGoMap(m map[string]interface{}, stamps map[string]int64) *GoMap {
 	g := &GoMap{
-		get:  make(chan mapGet),
-		set:  make(chan mapSet),
-		done: make(chan bool),
-		m:    make(map[string]interface{}),
+		get:      make(chan mapGet),
+		set:      make(chan mapSet),
+		getOrSet: make(chan mapGetOrSet),
+		loadAll:  make(chan mapLoadAll),
+		incr:     make(chan mapIncrement),
+		contains: make(chan mapContainsValue),
+		mutate:   make(chan mapMutate),
+		claim:    make(chan mapClaimPrefix),
+		loadDel:  make(chan mapLoadAndDelete),
+		setMulti: make(chan mapSetMulti),
+		getMulti: make(chan mapGetMulti),
+		delMulti: make(chan mapDeleteMulti),
+		snapshot: make(chan mapSnapshot),
+		newer:    make(chan mapSetIfNewer),
+		report:   make(chan mapSetAndReport),
+		acquire:  make(chan mapAcquire),
+		onChange: make(chan mapOnChange),
+		done:     make(chan struct{}),
+		m:        m,
+		stamps:   stamps,
 	}
 	go g.run()
 	return g
 }
 
 func (g *GoMap) run() {
-	defer func() { g.done <- true }()
+	defer close(g.done)
 	for {
 		select {
 		case r, ok := <-g.get:
@@ -62,6 +191,108 @@ func (g *GoMap) run() {
 				return
 			}
 			g.m[r.key] = r.value
+			g.notify(mapChange{"set", r.key, r.value})
+		case r, ok := <-g.getOrSet:
+			if !ok {
+				return
+			}
+			res := getOrSet(g.m, r)
+			if !res.loaded && res.err == nil {
+				g.notify(mapChange{"set", r.key, res.value})
+			}
+			r.out <- res
+		case r, ok := <-g.loadAll:
+			if !ok {
+				return
+			}
+			r.out <- loadAll(g.m)
+		case r, ok := <-g.incr:
+			if !ok {
+				return
+			}
+			value, overflowed, err := saturatingIncrement(g.m, r.key, r.delta)
+			if err == nil {
+				g.notify(mapChange{"set", r.key, value})
+			}
+			r.out <- mapIncrementResult{value, overflowed, err}
+		case r, ok := <-g.contains:
+			if !ok {
+				return
+			}
+			r.out <- containsValue(g.m, r.value)
+		case r, ok := <-g.mutate:
+			if !ok {
+				return
+			}
+			if value, stored := mutate(g.m, r); stored {
+				g.notify(mapChange{"set", r.key, value})
+			}
+		case r, ok := <-g.claim:
+			if !ok {
+				return
+			}
+			claimed := claimPrefix(g.m, g.stamps, r.prefix, r.limit)
+			g.notifyEntries("delete", claimed)
+			r.out <- claimed
+		case r, ok := <-g.loadDel:
+			if !ok {
+				return
+			}
+			res := loadAndDelete(g.m, g.stamps, r.key)
+			if res.ok {
+				g.notify(mapChange{"delete", r.key, nil})
+			}
+			r.out <- res
+		case r, ok := <-g.setMulti:
+			if !ok {
+				return
+			}
+			setMulti(g.m, r.entries)
+			g.notifyEntries("set", r.entries)
+		case r, ok := <-g.getMulti:
+			if !ok {
+				return
+			}
+			r.out <- getMulti(g.m, r.keys)
+		case r, ok := <-g.delMulti:
+			if !ok {
+				return
+			}
+			deleted := g.presentEntries(g.m, r.keys)
+			deleteMulti(g.m, g.stamps, r.keys)
+			g.notifyEntries("delete", deleted)
+		case r, ok := <-g.snapshot:
+			if !ok {
+				return
+			}
+			r.out <- snapshot(g.m)
+		case r, ok := <-g.newer:
+			if !ok {
+				return
+			}
+			stored := setIfNewer(g.m, g.stamps, r)
+			if stored {
+				g.notify(mapChange{"set", r.key, r.value})
+			}
+			r.out <- stored
+		case r, ok := <-g.report:
+			if !ok {
+				return
+			}
+			changed := setAndReport(g.m, r.key, r.value)
+			g.notify(mapChange{"set", r.key, r.value})
+			r.out <- changed
+		case r, ok := <-g.acquire:
+			if !ok {
+				return
+			}
+			close(r.acquired)
+			g.notify(<-r.release...)
+		case r, ok := <-g.onChange:
+			if !ok {
+				return
+			}
+			g.hooks = append(g.hooks, r.fn)
 		}
 	}
 }
@@ -69,9 +300,40 @@ func (g *GoMap) run() {
 func (g *GoMap) Stop() {
 	close(g.get)
 	close(g.set)
+	close(g.getOrSet)
+	close(g.loadAll)
+	close(g.incr)
+	close(g.contains)
+	close(g.mutate)
+	close(g.claim)
+	close(g.loadDel)
+	close(g.setMulti)
+	close(g.getMulti)
+	close(g.delMulti)
+	close(g.snapshot)
+	close(g.newer)
+	close(g.report)
+	close(g.acquire)
+	close(g.onChange)
 	<-g.done
 }
 
+// Done returns a channel that is closed once the owning goroutine has exited
+// after Stop.
+func (g *GoMap) Done() <-chan struct{} {
+	return g.done
+}
+
+// OnChange registers fn to be called after every change to the map, with
+// the same arguments as SyncMap.OnChange. Hooks run one change at a time,
+// in the order the owning goroutine made the changes, on a goroutine of
+// their own, so they may read and change the map. A hook may therefore run
+// after the call that made its change has returned, even after Stop, and
+// must not use a stopped map.
+func (g *GoMap) OnChange(fn func(op string, key string, value interface{})) {
+	g.onChange <- mapOnChange{fn}
+}
+
 func (g *GoMap) Get(key string) (interface{}, bool) {
 	c := make(chan mapResult)
 	g.get <- mapGet{key, c}
@@ -83,21 +345,466 @@ func (g *GoMap) Set(key string, value interface{}) {
 	g.set <- mapSet{key, value}
 }
 
+// GetOrSetFunc returns the existing value for key, or stores and returns the
+// result of fn if the key is absent. fn runs on the owning goroutine, so it
+// must not call back into the map.
+func (g *GoMap) GetOrSetFunc(key string, fn func() interface{}) (actual interface{}, loaded bool) {
+	actual, loaded, _ = g.GetOrSetFuncTimeout(key, fn, 0)
+	return actual, loaded
+}
+
+// GetOrSetFuncTimeout is like GetOrSetFunc, but if fn hasn't returned within
+// timeout it is abandoned, nothing is stored and ErrCallbackTimeout is
+// returned, so a hanging fn can't wedge the owning goroutine. The abandoned
+// fn keeps running on its own goroutine and its result is discarded. A
+// timeout of zero or less waits for fn forever.
+func (g *GoMap) GetOrSetFuncTimeout(key string, fn func() interface{}, timeout time.Duration) (actual interface{}, loaded bool, err error) {
+	c := make(chan mapGetOrSetResult)
+	g.getOrSet <- mapGetOrSet{key, fn, timeout, c}
+	r := <-c
+	return r.value, r.loaded, r.err
+}
+
+// GetCopy is like Get, but the value is copied with utils.DeepCopy before
+// being returned so the caller can't mutate the stored value: slices, maps
+// and pointers to anything but structs are copied, other types are returned
+// as is.
+func (g *GoMap) GetCopy(key string) (interface{}, bool) {
+	value, ok := g.Get(key)
+	return utils.DeepCopy(value), ok
+}
+
+// LoadAll returns every entry of the map, read in a single message on the
+// owning goroutine so the result is consistent with one point in time.
+func (g *GoMap) LoadAll() []MapEntry {
+	c := make(chan []MapEntry)
+	g.loadAll <- mapLoadAll{c}
+	return <-c
+}
+
+// SaturatingIncrement adds delta to the int64 stored at key, clamping at
+// math.MaxInt64 or math.MinInt64 instead of wrapping around, and reports
+// whether it clamped. A missing value counts as zero. If key holds a value
+// that isn't an int64 it is left untouched and ErrNotInt64 is returned.
+func (g *GoMap) SaturatingIncrement(key string, delta int64) (value int64, overflowed bool, err error) {
+	c := make(chan mapIncrementResult)
+	g.incr <- mapIncrement{key, delta, c}
+	r := <-c
+	return r.value, r.overflowed, r.err
+}
+
+// ContainsValue reports whether any entry holds a value equal (==) to value.
+// It scans the whole map. Uncomparable values never match.
+func (g *GoMap) ContainsValue(value interface{}) bool {
+	c := make(chan bool)
+	g.contains <- mapContainsValue{value, c}
+	return <-c
+}
+
+var ErrCallbackTimeout = errors.New("map callback timed out")
+
+var ErrNotInt64 = errors.New("map value is not an int64")
+
+// Mutate atomically reads key, calls fn with the current value and lets it
+// decide the new value and whether to store it, all in a single message to
+// the owning goroutine. fn runs on the owning goroutine, so it must not call
+// back into the map.
+func (g *GoMap) Mutate(key string, fn func(old interface{}, ok bool) (new interface{}, store bool)) {
+	g.mutate <- mapMutate{key, fn, 0, nil}
+}
+
+// MutateTimeout is like Mutate, but waits for the outcome and, if fn hasn't
+// returned within timeout, abandons it like GetOrSetFuncTimeout does: nothing
+// is stored and ErrCallbackTimeout is returned.
+func (g *GoMap) MutateTimeout(key string, fn func(old interface{}, ok bool) (new interface{}, store bool), timeout time.Duration) error {
+	c := make(chan error)
+	g.mutate <- mapMutate{key, fn, timeout, c}
+	return <-c
+}
+
+// ClaimPrefix removes and returns up to limit entries whose key starts with
+// prefix, in one message to the owning goroutine, so competing callers never
+// claim the same entry. A limit of zero or less claims every matching entry.
+func (g *GoMap) ClaimPrefix(prefix string, limit int) []MapEntry {
+	c := make(chan []MapEntry)
+	g.claim <- mapClaimPrefix{prefix, limit, c}
+	return <-c
+}
+
+// LoadAndDelete removes key and returns the value it held.
+func (g *GoMap) LoadAndDelete(key string) (interface{}, bool) {
+	c := make(chan mapResult)
+	g.loadDel <- mapLoadAndDelete{key, c}
+	r := <-c
+	return r.value, r.ok
+}
+
+// SetIfNewer stores value at key only if timestamp is strictly greater than
+// the timestamp of the last SetIfNewer that stored key, and reports whether
+// it did, so out of order updates resolve to the latest write. Keys written
+// with Set carry no timestamp. Deleting a key forgets its timestamp, so
+// timestamps don't pile up under key churn, but a late, older write can then
+// bring the key back.
+func (g *GoMap) SetIfNewer(key string, value interface{}, timestamp int64) bool {
+	c := make(chan bool)
+	g.newer <- mapSetIfNewer{key, value, timestamp, c}
+	return <-c
+}
+
+// SetAndReport stores value at key and reports whether it changed anything:
+// true if key was absent or held a value != value. Uncomparable values
+// always count as changed.
+func (g *GoMap) SetAndReport(key string, value interface{}) bool {
+	c := make(chan bool)
+	g.report <- mapSetAndReport{key, value, c}
+	return <-c
+}
+
+// SetMulti sets all entries in a single message to the owning goroutine.
+func (g *GoMap) SetMulti(entries map[string]interface{}) {
+	g.SetMultiContext(context.Background(), entries)
+}
+
+// SetMultiContext is like SetMulti, but gives up with the context error if
+// ctx is done before the owning goroutine takes the message. The entries
+// are then left untouched; once taken, they are all set.
+func (g *GoMap) SetMultiContext(ctx context.Context, entries map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case g.setMulti <- mapSetMulti{mapToEntries(entries)}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetMulti returns the values of those keys that are present, read in a
+// single message to the owning goroutine.
+func (g *GoMap) GetMulti(keys []string) map[string]interface{} {
+	values, _ := g.GetMultiContext(context.Background(), keys)
+	return values
+}
+
+// GetMultiContext is like GetMulti, but gives up with the context error if
+// ctx is done before the owning goroutine takes the message.
+func (g *GoMap) GetMultiContext(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := make(chan map[string]interface{})
+	select {
+	case g.getMulti <- mapGetMulti{append([]string(nil), keys...), c}:
+		return <-c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// DeleteMulti deletes all keys in a single message to the owning goroutine.
+func (g *GoMap) DeleteMulti(keys []string) {
+	g.DeleteMultiContext(context.Background(), keys)
+}
+
+// DeleteMultiContext is like DeleteMulti, but gives up with the context
+// error if ctx is done before the owning goroutine takes the message. No key
+// is deleted then; once taken, they all are.
+func (g *GoMap) DeleteMultiContext(ctx context.Context, keys []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case g.delMulti <- mapDeleteMulti{append([]string(nil), keys...)}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Snapshot returns a copy of the map taken on the owning goroutine.
+func (g *GoMap) Snapshot() map[string]interface{} {
+	m, _ := g.SnapshotContext(context.Background())
+	return m
+}
+
+// SnapshotContext is like Snapshot, but gives up with the context error if
+// ctx is done before the owning goroutine takes the message.
+func (g *GoMap) SnapshotContext(ctx context.Context) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := make(chan map[string]interface{})
+	select {
+	case g.snapshot <- mapSnapshot{c}:
+		return <-c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Clone returns a new, independently running GoMap holding a copy of the
+// entries and of their SetIfNewer timestamps, taken on the owning
+// goroutine. Both maps must be stopped.
+func (g *GoMap) Clone() *GoMap {
+	m, stamps, release := g.exclusive()
+	defer release()
+	return newGoMap(snapshot(m), copyStamps(stamps))
+}
+
+// ownerHooks holds the change hooks of a map run by an owning goroutine,
+// the only one to use it.
+type ownerHooks struct {
+	hooks   []func(op string, key string, value interface{})
+	changes changeQueue
+}
+
+// notify queues changes for the hooks and, unless a dispatch is already
+// running, starts one on its own goroutine, leaving the owning goroutine
+// free to serve the hooks.
+func (h *ownerHooks) notify(changes ...mapChange) {
+	if h.changes.queue(changes, h.hooks, nil) {
+		go h.changes.dispatch()
+	}
+}
+
+// notifyEntries notifies a change with op, "set" or "delete", of every entry.
+func (h *ownerHooks) notifyEntries(op string, entries []MapEntry) {
+	if len(h.hooks) == 0 {
+		return
+	}
+	changes := make([]mapChange, len(entries))
+	for i, e := range entries {
+		changes[i] = mapChange{op, e.Key, nil}
+		if op == "set" {
+			changes[i].value = e.Value
+		}
+	}
+	h.notify(changes...)
+}
+
+// presentEntries returns the entries of m for those keys that are present,
+// so their deletion can be notified, or nil if there are no hooks.
+func (h *ownerHooks) presentEntries(m map[string]interface{}, keys []string) []MapEntry {
+	if len(h.hooks) == 0 {
+		return nil
+	}
+	var entries []MapEntry
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			entries = append(entries, MapEntry{k, v})
+		}
+	}
+	return entries
+}
+
+func copyStamps(stamps map[string]int64) map[string]int64 {
+	c := make(map[string]int64, len(stamps))
+	for k, v := range stamps {
+		c[k] = v
+	}
+	return c
+}
+
+func getOrSet(m map[string]interface{}, r mapGetOrSet) mapGetOrSetResult {
+	if value, ok := m[r.key]; ok {
+		return mapGetOrSetResult{value, true, nil}
+	}
+	value, err := runCallback(r.fn, r.timeout)
+	if err != nil {
+		return mapGetOrSetResult{nil, false, err}
+	}
+	m[r.key] = value
+	return mapGetOrSetResult{value, false, nil}
+}
+
+// runCallback runs fn, giving up on it after timeout if timeout is positive.
+func runCallback(fn func() interface{}, timeout time.Duration) (interface{}, error) {
+	if timeout <= 0 {
+		return fn(), nil
+	}
+	out := make(chan interface{}, 1)
+	go func() { out <- fn() }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case value := <-out:
+		return value, nil
+	case <-timer.C:
+		return nil, ErrCallbackTimeout
+	}
+}
+
+func saturatingIncrement(m map[string]interface{}, key string, delta int64) (int64, bool, error) {
+	var current int64
+	if old, ok := m[key]; ok {
+		if current, ok = old.(int64); !ok {
+			return 0, false, ErrNotInt64
+		}
+	}
+	value, overflowed := current+delta, false
+	if delta > 0 && current > math.MaxInt64-delta {
+		value, overflowed = math.MaxInt64, true
+	} else if delta < 0 && current < math.MinInt64-delta {
+		value, overflowed = math.MinInt64, true
+	}
+	m[key] = value
+	return value, overflowed, nil
+}
+
+// mutate runs r and returns the value it stored, if any.
+func mutate(m map[string]interface{}, r mapMutate) (interface{}, bool) {
+	type result struct {
+		value interface{}
+		store bool
+	}
+	old, ok := m[r.key]
+	out, err := runCallback(func() interface{} {
+		value, store := r.fn(old, ok)
+		return result{value, store}
+	}, r.timeout)
+	var res result
+	if err == nil {
+		if res = out.(result); res.store {
+			m[r.key] = res.value
+		}
+	}
+	if r.out != nil {
+		r.out <- err
+	}
+	return res.value, res.store
+}
+
+func setIfNewer(m map[string]interface{}, stamps map[string]int64, r mapSetIfNewer) bool {
+	if stamp, ok := stamps[r.key]; ok && r.timestamp <= stamp {
+		return false
+	}
+	m[r.key] = r.value
+	stamps[r.key] = r.timestamp
+	return true
+}
+
+func setAndReport(m map[string]interface{}, key string, value interface{}) bool {
+	old, ok := m[key]
+	m[key] = value
+	return !ok || !valuesEqual(old, value)
+}
+
+func mapToEntries(m map[string]interface{}) []MapEntry {
+	entries := make([]MapEntry, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, MapEntry{k, v})
+	}
+	return entries
+}
+
+func setMulti(m map[string]interface{}, entries []MapEntry) {
+	for _, e := range entries {
+		m[e.Key] = e.Value
+	}
+}
+
+func getMulti(m map[string]interface{}, keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func deleteMulti(m map[string]interface{}, stamps map[string]int64, keys []string) {
+	for _, k := range keys {
+		delete(m, k)
+		delete(stamps, k)
+	}
+}
+
+func snapshot(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func loadAndDelete(m map[string]interface{}, stamps map[string]int64, key string) mapResult {
+	value, ok := m[key]
+	delete(m, key)
+	delete(stamps, key)
+	return mapResult{value, ok}
+}
+
+func claimPrefix(m map[string]interface{}, stamps map[string]int64, prefix string, limit int) []MapEntry {
+	var claimed []MapEntry
+	for k, v := range m {
+		if limit > 0 && len(claimed) == limit {
+			break
+		}
+		if strings.HasPrefix(k, prefix) {
+			claimed = append(claimed, MapEntry{k, v})
+			delete(m, k)
+			delete(stamps, k)
+		}
+	}
+	return claimed
+}
+
+func containsValue(m map[string]interface{}, value interface{}) bool {
+	for _, v := range m {
+		if valuesEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesEqual compares a and b with ==, treating values that panic because
+// they hold uncomparable types as not equal.
+func valuesEqual(a, b interface{}) (equal bool) {
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+	return a == b
+}
+
+func loadAll(m map[string]interface{}) []MapEntry {
+	entries := make([]MapEntry, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, MapEntry{k, v})
+	}
+	return entries
+}
+
 ///////////////////////////////// SINGLE CHANNEL GO ROUTINE BASED MAP /////////////////////////
+
 type GoMap1Chan struct {
-	in   chan interface{}
-	done chan bool
-	m    map[string]interface{}
+	in     chan interface{}
+	done   chan struct{}
+	m      map[string]interface{}
+	stamps map[string]int64
+	ownerHooks
 }
 
 func NewGoMap1Chan() *GoMap1Chan {
-	g := &GoMap1Chan{in: make(chan interface{}), done: make(chan bool), m: make(map[string]interface{})}
+	return NewGoMap1ChanSize(0)
+}
+
+func NewGoMap1ChanSize(n int) *GoMap1Chan {
+	return newGoMap1Chan(make(map[string]interface{}, n), make(map[string]int64))
+}
+
+func newGoMap1Chan(m map[string]interface{}, stamps map[string]int64) *GoMap1Chan {
+	g := &GoMap1Chan{in: make(chan interface{}), done: make(chan struct{}), m: m, stamps: stamps}
 	go g.run()
 	return g
 }
 
 func (g *GoMap1Chan) run() {
-	defer func() { g.done <- true }()
+	defer close(g.done)
 	for i := range g.in {
 		switch r := i.(type) {
 		case mapGet:
@@ -105,6 +812,63 @@ func (g *GoMap1Chan) run() {
 			r.out <- mapResult{value, ok}
 		case mapSet:
 			g.m[r.key] = r.value
+			g.notify(mapChange{"set", r.key, r.value})
+		case mapGetOrSet:
+			res := getOrSet(g.m, r)
+			if !res.loaded && res.err == nil {
+				g.notify(mapChange{"set", r.key, res.value})
+			}
+			r.out <- res
+		case mapLoadAll:
+			r.out <- loadAll(g.m)
+		case mapIncrement:
+			value, overflowed, err := saturatingIncrement(g.m, r.key, r.delta)
+			if err == nil {
+				g.notify(mapChange{"set", r.key, value})
+			}
+			r.out <- mapIncrementResult{value, overflowed, err}
+		case mapContainsValue:
+			r.out <- containsValue(g.m, r.value)
+		case mapMutate:
+			if value, stored := mutate(g.m, r); stored {
+				g.notify(mapChange{"set", r.key, value})
+			}
+		case mapClaimPrefix:
+			claimed := claimPrefix(g.m, g.stamps, r.prefix, r.limit)
+			g.notifyEntries("delete", claimed)
+			r.out <- claimed
+		case mapLoadAndDelete:
+			res := loadAndDelete(g.m, g.stamps, r.key)
+			if res.ok {
+				g.notify(mapChange{"delete", r.key, nil})
+			}
+			r.out <- res
+		case mapSetMulti:
+			setMulti(g.m, r.entries)
+			g.notifyEntries("set", r.entries)
+		case mapGetMulti:
+			r.out <- getMulti(g.m, r.keys)
+		case mapDeleteMulti:
+			deleted := g.presentEntries(g.m, r.keys)
+			deleteMulti(g.m, g.stamps, r.keys)
+			g.notifyEntries("delete", deleted)
+		case mapSnapshot:
+			r.out <- snapshot(g.m)
+		case mapSetIfNewer:
+			stored := setIfNewer(g.m, g.stamps, r)
+			if stored {
+				g.notify(mapChange{"set", r.key, r.value})
+			}
+			r.out <- stored
+		case mapSetAndReport:
+			changed := setAndReport(g.m, r.key, r.value)
+			g.notify(mapChange{"set", r.key, r.value})
+			r.out <- changed
+		case mapAcquire:
+			close(r.acquired)
+			g.notify(<-r.release...)
+		case mapOnChange:
+			g.hooks = append(g.hooks, r.fn)
 		default:
 			panic("Unknown type on GoMap1Chan in")
 		}
@@ -116,6 +880,16 @@ func (g *GoMap1Chan) Stop() {
 	<-g.done
 }
 
+// Done behaves like GoMap.Done.
+func (g *GoMap1Chan) Done() <-chan struct{} {
+	return g.done
+}
+
+// OnChange behaves like GoMap.OnChange.
+func (g *GoMap1Chan) OnChange(fn func(op string, key string, value interface{})) {
+	g.in <- mapOnChange{fn}
+}
+
 func (g *GoMap1Chan) Get(key string) (interface{}, bool) {
 	c := make(chan mapResult)
 	g.in <- mapGet{key, c}
@@ -127,15 +901,337 @@ func (g *GoMap1Chan) Set(key string, value interface{}) {
 	g.in <- mapSet{key, value}
 }
 
+// GetOrSetFunc behaves like GoMap.GetOrSetFunc.
+func (g *GoMap1Chan) GetOrSetFunc(key string, fn func() interface{}) (actual interface{}, loaded bool) {
+	actual, loaded, _ = g.GetOrSetFuncTimeout(key, fn, 0)
+	return actual, loaded
+}
+
+// GetOrSetFuncTimeout behaves like GoMap.GetOrSetFuncTimeout.
+func (g *GoMap1Chan) GetOrSetFuncTimeout(key string, fn func() interface{}, timeout time.Duration) (actual interface{}, loaded bool, err error) {
+	c := make(chan mapGetOrSetResult)
+	g.in <- mapGetOrSet{key, fn, timeout, c}
+	r := <-c
+	return r.value, r.loaded, r.err
+}
+
+// GetCopy behaves like GoMap.GetCopy.
+func (g *GoMap1Chan) GetCopy(key string) (interface{}, bool) {
+	value, ok := g.Get(key)
+	return utils.DeepCopy(value), ok
+}
+
+// SaturatingIncrement behaves like GoMap.SaturatingIncrement.
+func (g *GoMap1Chan) SaturatingIncrement(key string, delta int64) (value int64, overflowed bool, err error) {
+	c := make(chan mapIncrementResult)
+	g.in <- mapIncrement{key, delta, c}
+	r := <-c
+	return r.value, r.overflowed, r.err
+}
+
+// Mutate behaves like GoMap.Mutate.
+func (g *GoMap1Chan) Mutate(key string, fn func(old interface{}, ok bool) (new interface{}, store bool)) {
+	g.in <- mapMutate{key, fn, 0, nil}
+}
+
+// MutateTimeout behaves like GoMap.MutateTimeout.
+func (g *GoMap1Chan) MutateTimeout(key string, fn func(old interface{}, ok bool) (new interface{}, store bool), timeout time.Duration) error {
+	c := make(chan error)
+	g.in <- mapMutate{key, fn, timeout, c}
+	return <-c
+}
+
+// ClaimPrefix behaves like GoMap.ClaimPrefix.
+func (g *GoMap1Chan) ClaimPrefix(prefix string, limit int) []MapEntry {
+	c := make(chan []MapEntry)
+	g.in <- mapClaimPrefix{prefix, limit, c}
+	return <-c
+}
+
+// ContainsValue behaves like GoMap.ContainsValue.
+func (g *GoMap1Chan) ContainsValue(value interface{}) bool {
+	c := make(chan bool)
+	g.in <- mapContainsValue{value, c}
+	return <-c
+}
+
+// LoadAndDelete behaves like GoMap.LoadAndDelete.
+func (g *GoMap1Chan) LoadAndDelete(key string) (interface{}, bool) {
+	c := make(chan mapResult)
+	g.in <- mapLoadAndDelete{key, c}
+	r := <-c
+	return r.value, r.ok
+}
+
+// SetIfNewer behaves like GoMap.SetIfNewer.
+func (g *GoMap1Chan) SetIfNewer(key string, value interface{}, timestamp int64) bool {
+	c := make(chan bool)
+	g.in <- mapSetIfNewer{key, value, timestamp, c}
+	return <-c
+}
+
+// SetAndReport behaves like GoMap.SetAndReport.
+func (g *GoMap1Chan) SetAndReport(key string, value interface{}) bool {
+	c := make(chan bool)
+	g.in <- mapSetAndReport{key, value, c}
+	return <-c
+}
+
+// SetMulti behaves like GoMap.SetMulti.
+func (g *GoMap1Chan) SetMulti(entries map[string]interface{}) {
+	g.SetMultiContext(context.Background(), entries)
+}
+
+// SetMultiContext behaves like GoMap.SetMultiContext.
+func (g *GoMap1Chan) SetMultiContext(ctx context.Context, entries map[string]interface{}) error {
+	return g.send(ctx, mapSetMulti{mapToEntries(entries)})
+}
+
+// GetMulti behaves like GoMap.GetMulti.
+func (g *GoMap1Chan) GetMulti(keys []string) map[string]interface{} {
+	values, _ := g.GetMultiContext(context.Background(), keys)
+	return values
+}
+
+// GetMultiContext behaves like GoMap.GetMultiContext.
+func (g *GoMap1Chan) GetMultiContext(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	c := make(chan map[string]interface{})
+	if err := g.send(ctx, mapGetMulti{append([]string(nil), keys...), c}); err != nil {
+		return nil, err
+	}
+	return <-c, nil
+}
+
+// DeleteMulti behaves like GoMap.DeleteMulti.
+func (g *GoMap1Chan) DeleteMulti(keys []string) {
+	g.DeleteMultiContext(context.Background(), keys)
+}
+
+// DeleteMultiContext behaves like GoMap.DeleteMultiContext.
+func (g *GoMap1Chan) DeleteMultiContext(ctx context.Context, keys []string) error {
+	return g.send(ctx, mapDeleteMulti{append([]string(nil), keys...)})
+}
+
+// Snapshot behaves like GoMap.Snapshot.
+func (g *GoMap1Chan) Snapshot() map[string]interface{} {
+	m, _ := g.SnapshotContext(context.Background())
+	return m
+}
+
+// SnapshotContext behaves like GoMap.SnapshotContext.
+func (g *GoMap1Chan) SnapshotContext(ctx context.Context) (map[string]interface{}, error) {
+	c := make(chan map[string]interface{})
+	if err := g.send(ctx, mapSnapshot{c}); err != nil {
+		return nil, err
+	}
+	return <-c, nil
+}
+
+// send hands msg to the owning goroutine, unless ctx is done first.
+func (g *GoMap1Chan) send(ctx context.Context, msg interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case g.in <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Clone behaves like GoMap.Clone.
+func (g *GoMap1Chan) Clone() *GoMap1Chan {
+	m, stamps, release := g.exclusive()
+	defer release()
+	return newGoMap1Chan(snapshot(m), copyStamps(stamps))
+}
+
+// LoadAll behaves like GoMap.LoadAll.
+func (g *GoMap1Chan) LoadAll() []MapEntry {
+	c := make(chan []MapEntry)
+	g.in <- mapLoadAll{c}
+	return <-c
+}
+
 //////////////////////////////////// SYNC BASED MAP //////////////////////////////////
 
 type SyncMap struct {
-	lock sync.RWMutex
-	m    map[string]interface{}
+	lock     statsRWMutex
+	m        map[string]interface{}
+	flight   flightGroup
+	stamps   map[string]int64
+	hooks    []func(op string, key string, value interface{})
+	watchers map[string][]*keyWatcher
+	changes  changeQueue
+}
+
+type mapChange struct {
+	op    string
+	key   string
+	value interface{}
+}
+
+// pendingChange is a change waiting to be delivered, along with the hooks
+// and watchers registered when it was made.
+type pendingChange struct {
+	mapChange
+	hooks    []func(op string, key string, value interface{})
+	watchers []*keyWatcher
+}
+
+// changeQueue delivers map changes to change hooks and key watchers one at a
+// time, in the order they were queued, with no map lock held. Its lock guards
+// pending and dispatching and is never held while a hook runs.
+type changeQueue struct {
+	lock        sync.Mutex
+	pending     []pendingChange
+	dispatching bool
+}
+
+// queue queues changes for delivery to hooks and to the watchers of their
+// keys. Callers must serialize calls in the order the changes were made, by
+// holding the map lock or running on the owning goroutine. queue reports
+// whether the caller must call dispatch, once it no longer holds the map.
+func (q *changeQueue) queue(changes []mapChange, hooks []func(op string, key string, value interface{}), watchers map[string][]*keyWatcher) bool {
+	if len(changes) == 0 || (len(hooks) == 0 && len(watchers) == 0) {
+		return false
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, c := range changes {
+		q.pending = append(q.pending, pendingChange{c, hooks, watchers[c.key]})
+	}
+	if q.dispatching {
+		return false
+	}
+	q.dispatching = true
+	return true
+}
+
+// dispatch delivers queued changes until the queue is empty. Only one
+// goroutine dispatches at a time, which keeps the delivery order.
+func (q *changeQueue) dispatch() {
+	for {
+		q.lock.Lock()
+		batch := q.pending
+		q.pending = nil
+		if len(batch) == 0 {
+			q.dispatching = false
+			q.lock.Unlock()
+			return
+		}
+		q.lock.Unlock()
+		for _, c := range batch {
+			for _, fn := range c.hooks {
+				fn(c.op, c.key, c.value)
+			}
+			for _, w := range c.watchers {
+				w.send(KeyEvent{c.op, c.key, c.value})
+			}
+		}
+	}
+}
+
+// KeyEvent describes a change to a watched key, see SyncMap.WatchKey.
+type KeyEvent struct {
+	Op    string
+	Key   string
+	Value interface{}
+}
+
+const watchBufferSize = 64
+
+type keyWatcher struct {
+	lock   sync.Mutex
+	c      chan KeyEvent
+	closed bool
+}
+
+func (w *keyWatcher) send(e KeyEvent) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.c <- e:
+	default:
+	}
+}
+
+func (w *keyWatcher) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	close(w.c)
 }
 
 func NewSyncMap() *SyncMap {
-	return &SyncMap{m: make(map[string]interface{})}
+	return NewSyncMapSize(0)
+}
+
+func NewSyncMapSize(n int) *SyncMap {
+	return &SyncMap{m: make(map[string]interface{}, n), stamps: make(map[string]int64)}
+}
+
+// OnChange registers fn to be called after every change to the map, with op
+// "set" and the new value, or op "delete" and a nil value. Hooks run with no
+// map lock held, one change at a time and in the order the changes were
+// made, so they may read and even change the map. Changes made while hooks
+// are running are queued and delivered by the goroutine already running
+// them, so a hook may run after the call that made its change has returned.
+func (s *SyncMap) OnChange(fn func(op string, key string, value interface{})) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hooks = append(s.hooks, fn)
+}
+
+// WatchKey returns a channel receiving an event for every change to key,
+// like the OnChange hooks but for one key only, and a function that stops
+// the watch and closes the channel. The channel buffers a few events; a
+// watcher that falls further behind misses events rather than blocking
+// writers.
+func (s *SyncMap) WatchKey(key string) (<-chan KeyEvent, func()) {
+	w := &keyWatcher{c: make(chan KeyEvent, watchBufferSize)}
+	s.lock.Lock()
+	if s.watchers == nil {
+		s.watchers = make(map[string][]*keyWatcher)
+	}
+	s.watchers[key] = append(s.watchers[key], w)
+	s.lock.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.lock.Lock()
+			watchers := s.watchers[key]
+			for i, v := range watchers {
+				if v == w {
+					watchers = append(watchers[:i:i], watchers[i+1:]...)
+					break
+				}
+			}
+			if len(watchers) == 0 {
+				delete(s.watchers, key)
+			} else {
+				s.watchers[key] = watchers
+			}
+			s.lock.Unlock()
+			w.close()
+		})
+	}
+	return w.c, unsubscribe
+}
+
+// unlock releases the write lock, then delivers changes to the change hooks
+// and key watchers.
+func (s *SyncMap) unlock(changes ...mapChange) {
+	dispatch := s.changes.queue(changes, s.hooks, s.watchers)
+	s.lock.Unlock()
+	if dispatch {
+		s.changes.dispatch()
+	}
 }
 
 func (s *SyncMap) Get(key string) (interface{}, bool) {
@@ -145,10 +1241,195 @@ func (s *SyncMap) Get(key string) (interface{}, bool) {
 	return value, ok
 }
 
+// Snapshot returns a copy of the map taken under the read lock.
+func (s *SyncMap) Snapshot() map[string]interface{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return snapshot(s.m)
+}
+
+// GetCopy is like Get, but the value is copied with utils.DeepCopy before
+// being returned so the caller can't mutate the stored value: slices, maps
+// and pointers to anything but structs are copied, other types are returned
+// as is.
+func (s *SyncMap) GetCopy(key string) (interface{}, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.m[key]
+	return utils.DeepCopy(value), ok
+}
+
 func (s *SyncMap) Set(key string, value interface{}) {
 	s.lock.Lock()
-	defer s.lock.Unlock()
 	s.m[key] = value
+	s.unlock(mapChange{"set", key, value})
+}
+
+func (s *SyncMap) Delete(key string) {
+	s.LoadAndDelete(key)
+}
+
+func (s *SyncMap) LoadAndDelete(key string) (interface{}, bool) {
+	s.lock.Lock()
+	r := loadAndDelete(s.m, s.stamps, key)
+	if !r.ok {
+		s.lock.Unlock()
+		return nil, false
+	}
+	s.unlock(mapChange{"delete", key, nil})
+	return r.value, r.ok
+}
+
+// GetOrSetFunc returns the existing value for key, or stores and returns the
+// result of fn if the key is absent. fn is only called on a miss and runs
+// while the write lock is held, so it must not call back into the map.
+func (s *SyncMap) GetOrSetFunc(key string, fn func() interface{}) (actual interface{}, loaded bool) {
+	if value, ok := s.Get(key); ok {
+		return value, true
+	}
+	s.lock.Lock()
+	unlocked := false
+	defer func() {
+		// Don't leave the map locked if fn panics.
+		if !unlocked {
+			s.lock.Unlock()
+		}
+	}()
+	if value, ok := s.m[key]; ok {
+		return value, true
+	}
+	value := fn()
+	s.m[key] = value
+	unlocked = true
+	s.unlock(mapChange{"set", key, value})
+	return value, false
+}
+
+// SetIfNewer behaves like GoMap.SetIfNewer.
+func (s *SyncMap) SetIfNewer(key string, value interface{}, timestamp int64) bool {
+	s.lock.Lock()
+	if !setIfNewer(s.m, s.stamps, mapSetIfNewer{key: key, value: value, timestamp: timestamp}) {
+		s.lock.Unlock()
+		return false
+	}
+	s.unlock(mapChange{"set", key, value})
+	return true
+}
+
+// SetAndReport behaves like GoMap.SetAndReport. Change hooks run like for
+// Set, whether or not the value changed.
+func (s *SyncMap) SetAndReport(key string, value interface{}) bool {
+	s.lock.Lock()
+	changed := setAndReport(s.m, key, value)
+	s.unlock(mapChange{"set", key, value})
+	return changed
+}
+
+// ClaimPrefix behaves like GoMap.ClaimPrefix.
+func (s *SyncMap) ClaimPrefix(prefix string, limit int) []MapEntry {
+	s.lock.Lock()
+	claimed := claimPrefix(s.m, s.stamps, prefix, limit)
+	changes := make([]mapChange, len(claimed))
+	for i, e := range claimed {
+		changes[i] = mapChange{"delete", e.Key, nil}
+	}
+	s.unlock(changes...)
+	return claimed
+}
+
+// ContainsValue behaves like GoMap.ContainsValue.
+func (s *SyncMap) ContainsValue(value interface{}) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return containsValue(s.m, value)
+}
+
+// SaturatingIncrement behaves like GoMap.SaturatingIncrement.
+func (s *SyncMap) SaturatingIncrement(key string, delta int64) (value int64, overflowed bool, err error) {
+	s.lock.Lock()
+	value, overflowed, err = saturatingIncrement(s.m, key, delta)
+	if err != nil {
+		s.lock.Unlock()
+		return 0, false, err
+	}
+	s.unlock(mapChange{"set", key, value})
+	return value, overflowed, nil
+}
+
+// GetOrSetLocked reads key and lets compute decide, from whether it exists and
+// its current value, the new value and whether to store it, all under a
+// single write lock so no other writer can slip in between. compute must not
+// call back into the map.
+func (s *SyncMap) GetOrSetLocked(key string, compute func(exists bool, old interface{}) (interface{}, bool)) {
+	s.lock.Lock()
+	unlocked := false
+	defer func() {
+		// Don't leave the map locked if compute panics.
+		if !unlocked {
+			s.lock.Unlock()
+		}
+	}()
+	old, exists := s.m[key]
+	value, store := compute(exists, old)
+	if !store {
+		return
+	}
+	s.m[key] = value
+	unlocked = true
+	s.unlock(mapChange{"set", key, value})
+}
+
+// GetOrComputeContext returns the value for key, calling loader to compute and
+// store it on a miss. Concurrent misses for the same key share a single
+// loader call, run with the context of the caller that started it. If that
+// context is done before loader returns, every caller sharing the call gets
+// the context error and the late result is not stored. A value Set while
+// loader runs wins over the loaded one, which is then discarded. If loader
+// panics, the panic is raised again in every caller sharing the call.
+func (s *SyncMap) GetOrComputeContext(ctx context.Context, key string, loader func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if value, ok := s.Get(key); ok {
+		return value, nil
+	}
+	c := s.flight.do(key, func() (interface{}, error) {
+		type result struct {
+			value      interface{}
+			err        error
+			panicked   bool
+			panicValue interface{}
+		}
+		out := make(chan result, 1)
+		go func() {
+			panicked := true
+			defer func() {
+				// Hand a panic over to be raised again in the callers.
+				if panicked {
+					out <- result{panicked: true, panicValue: recover()}
+				}
+			}()
+			value, err := loader(ctx)
+			panicked = false
+			out <- result{value: value, err: err}
+		}()
+		select {
+		case r := <-out:
+			if r.panicked {
+				panic(r.panicValue)
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			value, _ := s.GetOrSetFunc(key, func() interface{} { return r.value })
+			return value, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	select {
+	case <-c.done:
+		return c.result()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 //////////////////////////////////// THE TESTING CODE ////////////////////////////////
@@ -170,32 +1451,304 @@ func TheTest(g Map, rnd *rand.Rand) time.Duration {
 	return time.Now().Sub(start)
 }
 
-func TestInParallel(g Map, n int) time.Duration {
+// TheBurstTest sets 100000 keys in bursts of burst Sets, reading back the
+// last key of each burst, so batching producers get runs of writes to
+// batch.
+func TheBurstTest(g Map, rnd *rand.Rand, burst int) time.Duration {
+	start := time.Now()
+	var key string
+	var value string
+	for i := 0; i < 100000; i += burst {
+		for j := 0; j < burst; j++ {
+			key = strconv.Itoa(int(rnd.Int31n(500)))
+			value = "The value " + key
+			g.Set(key, value)
+		}
+		got, _ := g.Get(key)
+		if value != got {
+			panic(fmt.Sprintf("ERROR: expected %v, got %v", value, got))
+		}
+	}
+	return time.Now().Sub(start)
+}
+
+// TestInParallel runs TheTest from n goroutines, worker i drawing its keys
+// from a rand seeded with seed+i.
+func TestInParallel(g Map, n int, seed int64) time.Duration {
 	start := time.Now()
 	var wait sync.WaitGroup
 
 	for i := 0; i < n; i++ {
 		wait.Add(1)
-		go func() {
-			TheTest(g, rand.New(rand.NewSource(time.Now().Unix()+int64(i*500))))
+		go func(rnd *rand.Rand) {
+			TheTest(g, rnd)
 			wait.Done()
-		}()
+		}(rand.New(rand.NewSource(seed + int64(i))))
+	}
+	wait.Wait()
+	return time.Now().Sub(start)
+}
+
+// TestBurstsInParallel runs TheBurstTest from n goroutines, seeded like in
+// TestInParallel.
+func TestBurstsInParallel(g Map, n int, burst int, seed int64) time.Duration {
+	start := time.Now()
+	var wait sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wait.Add(1)
+		go func(rnd *rand.Rand) {
+			TheBurstTest(g, rnd, burst)
+			wait.Done()
+		}(rand.New(rand.NewSource(seed + int64(i))))
+	}
+	wait.Wait()
+	return time.Now().Sub(start)
+}
+
+// TestBatchedInParallel runs TheBurstTest from n goroutines, each going
+// through its own MapBatcher on g and seeded like in TestInParallel. Every
+// Get flushes the batcher, so batches only fill up to batchSize when burst
+// is larger than batchSize.
+func TestBatchedInParallel(g *GoMapBatched, n int, burst int, batchSize int, seed int64) time.Duration {
+	start := time.Now()
+	var wait sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wait.Add(1)
+		go func(rnd *rand.Rand) {
+			TheBurstTest(g.Batcher(batchSize), rnd, burst)
+			wait.Done()
+		}(rand.New(rand.NewSource(seed + int64(i))))
 	}
 	wait.Wait()
 	return time.Now().Sub(start)
 }
 
+// BulkLoad sets n distinct keys and reports the time taken and the number of
+// heap allocations it caused.
+func BulkLoad(g Map, n int) (time.Duration, uint64) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		g.Set(strconv.Itoa(i), i)
+	}
+	elapsed := time.Now().Sub(start)
+	runtime.ReadMemStats(&after)
+	return elapsed, after.Mallocs - before.Mallocs
+}
+
+// StressSyncMap hammers s from n goroutines with a random mix of its
+// operations over a small key space for duration d, and returns the number
+// of operations done. Worker i is seeded with seed+i, so the sequence of
+// operations each worker issues is reproducible, though how far it gets in d
+// is not. Run the benchmark with -race to check the locking.
+func StressSyncMap(s *SyncMap, n int, d time.Duration, seed int64) int64 {
+	var ops int64
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	deadline := time.Now().Add(d)
+
+	for i := 0; i < n; i++ {
+		wait.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wait.Done()
+			var count int64
+			for time.Now().Before(deadline) {
+				key := strconv.Itoa(int(rnd.Int31n(64)))
+				switch rnd.Intn(6) {
+				case 0, 1:
+					s.Set(key, count)
+				case 2:
+					s.Delete(key)
+				case 3:
+					s.GetOrSetFunc(key, func() interface{} { return count })
+				case 4:
+					s.SaturatingIncrement("counter", 1)
+				default:
+					s.Get(key)
+				}
+				count++
+			}
+			lock.Lock()
+			ops += count
+			lock.Unlock()
+		}(rand.New(rand.NewSource(seed + int64(i))))
+	}
+	wait.Wait()
+	return ops
+}
+
+// BytesPerEntry fills the map returned by newMap with n entries and reports
+// the growth of the live heap, after a GC, divided by n. The map's own fixed
+// cost, e.g. its channels, is included, so use a large n to get the cost of
+// an entry. Maps with a Stop method are stopped afterwards.
+func BytesPerEntry(newMap func() Map, n int) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	g := newMap()
+	for i := 0; i < n; i++ {
+		g.Set(strconv.Itoa(i), i)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if s, ok := g.(interface{ Stop() }); ok {
+		s.Stop()
+	}
+	return float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)) / float64(n)
+}
+
+// boundedSyncMapAsMap adapts a BoundedSyncMap, whose Set reports whether it
+// stored the value, to Map.
+type boundedSyncMapAsMap struct {
+	*BoundedSyncMap
+}
+
+func (b boundedSyncMapAsMap) Set(key string, value interface{}) {
+	b.BoundedSyncMap.Set(key, value)
+}
+
+type footprintMap struct {
+	name   string
+	newMap func() Map
+}
+
+// footprintMaps returns a constructor for every map kind, bounded ones
+// sized to hold n entries.
+func footprintMaps(n int) []footprintMap {
+	return []footprintMap{
+		{"GoMap:                 ", func() Map { return NewGoMap() }},
+		{"GoMap1Chan:            ", func() Map { return NewGoMap1Chan() }},
+		{"GoMapBatched:          ", func() Map { return NewGoMapBatched() }},
+		{"SyncMap:               ", func() Map { return NewSyncMap() }},
+		{"OrderedSyncMap:        ", func() Map { return NewOrderedSyncMap() }},
+		{"BoundedMap LRU:        ", func() Map { return NewBoundedMap(n, NewLRUPolicy()) }},
+		{"BoundedMap LFU:        ", func() Map { return NewBoundedMap(n, NewLFUPolicy()) }},
+		{"BoundedSyncMap reject: ", func() Map { return boundedSyncMapAsMap{NewBoundedSyncMap(n, BoundReject)} }},
+		{"BoundedSyncMap random: ", func() Map { return boundedSyncMapAsMap{NewBoundedSyncMap(n, BoundEvictRandom)} }},
+		{"TypedMap:              ", func() Map { return NewTypedMap() }},
+	}
+}
+
+// PrintFootprints prints the bytes per entry of each map implementation
+// filled with n entries.
+func PrintFootprints(n int) {
+	fmt.Println("Heap bytes per entry with", n, "entries")
+	for _, m := range footprintMaps(n) {
+		fmt.Printf("%s %.1f\n", m.name, BytesPerEntry(m.newMap, n))
+	}
+}
+
+// BenchmarkIntValues compares setting and getting int values through the
+// interface{} based SyncMap and through TypedMap's unboxed SetInt/GetInt.
+func BenchmarkIntValues() (boxed, typed testing.BenchmarkResult) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	boxed = testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		s := NewSyncMap()
+		for i := 0; i < b.N; i++ {
+			key := keys[i%len(keys)]
+			s.Set(key, int64(i+1000))
+			if v, _ := s.Get(key); v.(int64) != int64(i+1000) {
+				panic(fmt.Sprintf("ERROR: expected %v, got %v", i+1000, v))
+			}
+		}
+	})
+	typed = testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		t := NewTypedMap()
+		for i := 0; i < b.N; i++ {
+			key := keys[i%len(keys)]
+			t.SetInt(key, int64(i+1000))
+			if v, _ := t.GetInt(key); v != int64(i+1000) {
+				panic(fmt.Sprintf("ERROR: expected %v, got %v", i+1000, v))
+			}
+		}
+	})
+	return boxed, typed
+}
+
+// isFlagSet reports whether the flag name was set when fs was parsed.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
-	runtime.GOMAXPROCS(runtime.NumCPU())
+	seed := flag.Int64("seed", 0, "base seed of the workers' rand, worker i uses seed+i (picked from the clock if not set)")
+	procs := flag.Int("gomaxprocs", runtime.NumCPU(), "value of GOMAXPROCS")
+	footprint := flag.Int("footprint", 0, "only measure the heap bytes per entry of each map filled with this many entries")
+	flag.Parse()
+	if !isFlagSet(flag.CommandLine, "seed") {
+		*seed = time.Now().UnixNano()
+	}
+	runtime.GOMAXPROCS(*procs)
+	fmt.Println("Seed:", *seed, "GOMAXPROCS:", runtime.GOMAXPROCS(0))
+	if *footprint > 0 {
+		PrintFootprints(*footprint)
+		return
+	}
+
 	gm := NewGoMap()
 	gm1chan := NewGoMap1Chan()
 	sm := NewSyncMap()
 	nRoutines := 10
 	fmt.Println("In parallel on", runtime.NumCPU(), "CPUs with", nRoutines, "goroutines")
-	fmt.Println("GoMap:      ", TestInParallel(gm, nRoutines))
-	fmt.Println("GoMap1Chan: ", TestInParallel(gm1chan, nRoutines))
-	fmt.Println("SyncMap:    ", TestInParallel(sm, nRoutines))
+	fmt.Println("GoMap:      ", TestInParallel(gm, nRoutines, *seed))
+	fmt.Println("GoMap1Chan: ", TestInParallel(gm1chan, nRoutines, *seed))
+	fmt.Println("SyncMap:    ", TestInParallel(sm, nRoutines, *seed))
 
 	gm.Stop()
 	gm1chan.Stop()
+
+	nBatched, burst := 32, 256
+	gm = NewGoMap()
+	fmt.Println("In parallel with", nBatched, "goroutines, reading after bursts of", burst, "Sets")
+	fmt.Println("GoMap:                  ", TestBurstsInParallel(gm, nBatched, burst, *seed))
+	gm.Stop()
+	for _, batchSize := range []int{1, 16, 64, 256} {
+		gmBatched := NewGoMapBatched()
+		fmt.Printf("GoMapBatched (batch %3d): %v\n", batchSize, TestBatchedInParallel(gmBatched, nBatched, burst, batchSize, *seed))
+		gmBatched.Stop()
+	}
+
+	nLoad := 1000000
+	fmt.Println("Bulk loading", nLoad, "entries (time, allocations)")
+	printLoad := func(name string, g Map) {
+		d, allocs := BulkLoad(g, nLoad)
+		fmt.Println(name, d, allocs)
+	}
+	printLoad("SyncMap:              ", NewSyncMap())
+	printLoad("SyncMapSize:          ", NewSyncMapSize(nLoad))
+	gm = NewGoMap()
+	printLoad("GoMap:                ", gm)
+	gm.Stop()
+	gm = NewGoMapSize(nLoad)
+	printLoad("GoMapSize:            ", gm)
+	gm.Stop()
+	gm1chan = NewGoMap1Chan()
+	printLoad("GoMap1Chan:           ", gm1chan)
+	gm1chan.Stop()
+	gm1chan = NewGoMap1ChanSize(nLoad)
+	printLoad("GoMap1ChanSize:       ", gm1chan)
+	gm1chan.Stop()
+
+	nStress := 64
+	fmt.Println("SyncMap stress with", nStress, "goroutines:", StressSyncMap(NewSyncMap(), nStress, time.Second, *seed), "operations")
+
+	boxed, typed := BenchmarkIntValues()
+	fmt.Println("Set/Get of int values")
+	fmt.Println("SyncMap:  ", boxed, boxed.MemString())
+	fmt.Println("TypedMap: ", typed, typed.MemString())
 }
@@ -0,0 +1,1003 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitTimeout fails the test if wait doesn't return within d.
+func waitTimeout(t *testing.T, d time.Duration, wait func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal("timed out, deadlock?")
+	}
+}
+
+func TestSyncMapOnChangeOrder(t *testing.T) {
+	s := NewSyncMap()
+	type call struct {
+		op, key string
+		value   interface{}
+	}
+	var first, second []call
+	s.OnChange(func(op, key string, value interface{}) {
+		first = append(first, call{op, key, value})
+	})
+	s.OnChange(func(op, key string, value interface{}) {
+		second = append(second, call{op, key, value})
+	})
+	s.Set("a", 1)
+	s.Set("b", 2)
+	s.Delete("a")
+	s.Set("b", 3)
+	s.Delete("missing")
+
+	want := []call{{"set", "a", 1}, {"set", "b", 2}, {"delete", "a", nil}, {"set", "b", 3}}
+	for _, got := range [][]call{first, second} {
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("call %d: got %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestSyncMapOnChangeConcurrentOrder(t *testing.T) {
+	s := NewSyncMap()
+	var lock sync.Mutex
+	last := make(map[string]int)
+	s.OnChange(func(op, key string, value interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		if v := value.(int); v <= last[key] {
+			t.Errorf("key %s: got %d after %d", key, v, last[key])
+		} else {
+			last[key] = v
+		}
+	})
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func(key string) {
+			defer wait.Done()
+			for i := 1; i <= 1000; i++ {
+				s.Set(key, i)
+			}
+		}(strconv.Itoa(g))
+	}
+	wait.Wait()
+	for g := 0; g < 4; g++ {
+		if v := last[strconv.Itoa(g)]; v != 1000 {
+			t.Errorf("key %d: last hook value %d, want 1000", g, v)
+		}
+	}
+}
+
+func TestSyncMapOnChangeHookReadsAndWrites(t *testing.T) {
+	s := NewSyncMap()
+	s.OnChange(func(op, key string, value interface{}) {
+		s.Get(key)
+		if key != "mirror" {
+			s.Set("mirror", value)
+		}
+	})
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				s.Set("k", i)
+			}
+		}()
+	}
+	waitTimeout(t, 10*time.Second, wait.Wait)
+}
+
+func TestSyncMapUnsubscribeFromHook(t *testing.T) {
+	s := NewSyncMap()
+	c, stop := s.WatchKey("a")
+	s.OnChange(func(op, key string, value interface{}) {
+		if value == 2 {
+			stop()
+		}
+	})
+	waitTimeout(t, 5*time.Second, func() {
+		s.Set("a", 1)
+		s.Set("a", 2)
+		s.Set("a", 3)
+	})
+	var got []interface{}
+	for e := range c {
+		got = append(got, e.Value)
+	}
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("got %v, want [1]", got)
+	}
+}
+
+type changeHooker interface {
+	Map
+	OnChange(fn func(op string, key string, value interface{}))
+	LoadAndDelete(key string) (interface{}, bool)
+	DeleteMulti(keys []string)
+	Stop()
+}
+
+func TestOwnerMapOnChangeOrder(t *testing.T) {
+	type call struct {
+		op, key string
+		value   interface{}
+	}
+	for _, m := range []changeHooker{NewGoMap(), NewGoMap1Chan()} {
+		first, second := make(chan call, 100), make(chan call, 100)
+		m.OnChange(func(op, key string, value interface{}) {
+			// Hooks may use the map.
+			m.Get(key)
+			first <- call{op, key, value}
+		})
+		m.OnChange(func(op, key string, value interface{}) {
+			second <- call{op, key, value}
+		})
+		m.Set("a", 1)
+		m.Set("b", 2)
+		m.LoadAndDelete("a")
+		m.Set("b", 3)
+		m.LoadAndDelete("missing")
+		m.DeleteMulti([]string{"b", "missing"})
+
+		want := []call{{"set", "a", 1}, {"set", "b", 2}, {"delete", "a", nil}, {"set", "b", 3}, {"delete", "b", nil}}
+		for _, c := range []chan call{first, second} {
+			for i := range want {
+				select {
+				case got := <-c:
+					if got != want[i] {
+						t.Fatalf("%T: call %d: got %v, want %v", m, i, got, want[i])
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%T: call %d not delivered", m, i)
+				}
+			}
+		}
+		m.Stop()
+		select {
+		case got := <-first:
+			t.Fatalf("%T: unexpected call %v", m, got)
+		default:
+		}
+	}
+}
+
+func TestSyncMapCallbackPanicUnlocks(t *testing.T) {
+	s := NewSyncMap()
+	mustPanic := func(f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("callback did not panic")
+			}
+		}()
+		f()
+	}
+	mustPanic(func() {
+		s.GetOrSetFunc("a", func() interface{} { panic("fn") })
+	})
+	mustPanic(func() {
+		s.GetOrSetLocked("a", func(bool, interface{}) (interface{}, bool) { panic("compute") })
+	})
+	waitTimeout(t, 5*time.Second, func() {
+		s.Set("a", 1)
+	})
+}
+
+type mutator interface {
+	Map
+	Mutate(key string, fn func(old interface{}, ok bool) (interface{}, bool))
+	MutateTimeout(key string, fn func(old interface{}, ok bool) (interface{}, bool), timeout time.Duration) error
+}
+
+func TestMutateCounter(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []mutator{g, g1} {
+		var wait sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				for i := 0; i < 1000; i++ {
+					m.Mutate("n", func(old interface{}, ok bool) (interface{}, bool) {
+						if !ok {
+							return 1, true
+						}
+						return old.(int) + 1, true
+					})
+				}
+			}()
+		}
+		wait.Wait()
+		if v, _ := m.Get("n"); v != 8000 {
+			t.Fatalf("%T: got %v, want 8000", m, v)
+		}
+	}
+}
+
+func TestMutateTimeout(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []mutator{g, g1} {
+		m.Set("k", 1)
+		err := m.MutateTimeout("k", func(old interface{}, ok bool) (interface{}, bool) {
+			return old.(int) + 1, true
+		}, time.Second)
+		if v, _ := m.Get("k"); err != nil || v != 2 {
+			t.Fatalf("%T: got %v, %v, want 2, nil", m, v, err)
+		}
+
+		hang := make(chan struct{})
+		err = m.MutateTimeout("k", func(old interface{}, ok bool) (interface{}, bool) {
+			<-hang
+			return 100, true
+		}, 10*time.Millisecond)
+		close(hang)
+		if err != ErrCallbackTimeout {
+			t.Fatalf("%T: got %v, want ErrCallbackTimeout", m, err)
+		}
+		// The map must still serve requests and the abandoned result is
+		// never stored.
+		if v, _ := m.Get("k"); v != 2 {
+			t.Fatalf("%T: got %v after timeout, want 2", m, v)
+		}
+	}
+}
+
+type newerMap interface {
+	Map
+	exclusiveMap
+	SetIfNewer(key string, value interface{}, timestamp int64) bool
+	LoadAndDelete(key string) (interface{}, bool)
+	ClaimPrefix(prefix string, limit int) []MapEntry
+}
+
+func TestSetIfNewerStampsPrunedOnDelete(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []newerMap{NewSyncMap(), g, g1} {
+		for _, k := range []string{"a", "b", "p1", "p2"} {
+			m.SetIfNewer(k, 1, 10)
+		}
+		m.LoadAndDelete("a")
+		if d, ok := m.(interface{ DeleteMulti([]string) }); ok {
+			d.DeleteMulti([]string{"b"})
+		} else {
+			m.LoadAndDelete("b")
+		}
+		m.ClaimPrefix("p", 10)
+		_, stamps, release := m.exclusive()
+		n := len(stamps)
+		release()
+		if n != 0 {
+			t.Fatalf("%T: %d timestamps left after deleting every key", m, n)
+		}
+	}
+}
+
+func TestCloneCarriesStamps(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	g.SetIfNewer("k", 1, 10)
+	g1.SetIfNewer("k", 1, 10)
+	gc, g1c := g.Clone(), g1.Clone()
+	defer gc.Stop()
+	defer g1c.Stop()
+	for _, m := range []newerMap{gc, g1c} {
+		if m.SetIfNewer("k", 2, 5) {
+			t.Fatalf("%T: clone accepted a stale write", m)
+		}
+	}
+}
+
+func TestMigrateCarriesStamps(t *testing.T) {
+	src, dst := NewSyncMap(), NewSyncMap()
+	src.SetIfNewer("k", 1, 10)
+	if ok, err := Migrate(src, dst, "k"); !ok || err != nil {
+		t.Fatalf("Migrate = %v, %v", ok, err)
+	}
+	if dst.SetIfNewer("k", 2, 5) {
+		t.Fatal("migrated key accepted a stale write")
+	}
+	if len(src.stamps) != 0 {
+		t.Fatalf("source kept %d timestamps", len(src.stamps))
+	}
+}
+
+type copyGetter interface {
+	Map
+	GetCopy(key string) (interface{}, bool)
+}
+
+func TestGetCopy(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []copyGetter{NewSyncMap(), g, g1} {
+		n := 1
+		m.Set("s", []int{1})
+		m.Set("p", &n)
+		v, _ := m.GetCopy("s")
+		v.([]int)[0] = 100
+		p, _ := m.GetCopy("p")
+		*p.(*int) = 100
+		if v, _ := m.Get("s"); v.([]int)[0] != 1 || n != 1 {
+			t.Fatalf("%T: stored values changed to %v, %d", m, v, n)
+		}
+	}
+}
+
+func TestSizedBulkLoadAllocatesLess(t *testing.T) {
+	const n = 100000
+	_, unsized := BulkLoad(NewSyncMap(), n)
+	_, sized := BulkLoad(NewSyncMapSize(n), n)
+	if sized >= unsized {
+		t.Fatalf("sized map made %d allocations, unsized %d", sized, unsized)
+	}
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	const n = 1000000
+	for _, c := range []struct {
+		name string
+		new  func() Map
+	}{
+		{"SyncMap", func() Map { return NewSyncMap() }},
+		{"SyncMapSize", func() Map { return NewSyncMapSize(n) }},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := c.new()
+				for j := 0; j < n; j++ {
+					m.Set(strconv.Itoa(j), j)
+				}
+			}
+		})
+	}
+}
+
+type getOrSetFuncer interface {
+	Map
+	GetOrSetFunc(key string, fn func() interface{}) (actual interface{}, loaded bool)
+}
+
+func TestGetOrSetFunc(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []getOrSetFuncer{NewSyncMap(), g, g1} {
+		m.Set("hit", 1)
+		v, loaded := m.GetOrSetFunc("hit", func() interface{} {
+			t.Fatalf("%T: fn called on a hit", m)
+			return nil
+		})
+		if v != 1 || !loaded {
+			t.Fatalf("%T: hit got %v, %v", m, v, loaded)
+		}
+
+		var calls int32
+		var wait sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				v, _ := m.GetOrSetFunc("miss", func() interface{} {
+					atomic.AddInt32(&calls, 1)
+					return 2
+				})
+				if v != 2 {
+					t.Errorf("%T: got %v, want 2", m, v)
+				}
+			}()
+		}
+		wait.Wait()
+		if calls != 1 {
+			t.Fatalf("%T: fn called %d times, want 1", m, calls)
+		}
+	}
+}
+
+type loadAller interface {
+	Map
+	LoadAll() []MapEntry
+	Stop()
+}
+
+// TestLoadAllConsistent sets a then b to the same increasing value, so any
+// point in time snapshot has a equal to b or one ahead of it.
+func TestLoadAllConsistent(t *testing.T) {
+	for _, m := range []loadAller{NewGoMap(), NewGoMap1Chan()} {
+		m.Set("a", 0)
+		m.Set("b", 0)
+		stop := make(chan struct{})
+		var wait sync.WaitGroup
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 1; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.Set("a", i)
+				m.Set("b", i)
+			}
+		}()
+		for i := 0; i < 1000; i++ {
+			entries := m.LoadAll()
+			if len(entries) != 2 {
+				t.Fatalf("%T: got %d entries, want 2", m, len(entries))
+			}
+			values := make(map[string]int)
+			for _, e := range entries {
+				values[e.Key] = e.Value.(int)
+			}
+			if d := values["a"] - values["b"]; d != 0 && d != 1 {
+				t.Fatalf("%T: inconsistent snapshot %v", m, values)
+			}
+		}
+		close(stop)
+		wait.Wait()
+		m.Stop()
+	}
+}
+
+func TestGetOrComputeContextTimeout(t *testing.T) {
+	s := NewSyncMap()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	loaded := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		<-release
+		defer close(loaded)
+		return "slow", nil
+	}
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if _, err := s.GetOrComputeContext(ctx, "k", loader); err != context.DeadlineExceeded {
+				t.Errorf("got %v, want context.DeadlineExceeded", err)
+			}
+		}()
+	}
+	waitTimeout(t, 5*time.Second, wait.Wait)
+	close(release)
+	<-loaded
+	time.Sleep(10 * time.Millisecond)
+	if v, ok := s.Get("k"); ok {
+		t.Fatalf("slow result %v was stored", v)
+	}
+}
+
+func TestGetOrComputeContextLoaderPanic(t *testing.T) {
+	s := NewSyncMap()
+	loader := func(ctx context.Context) (interface{}, error) {
+		panic("loader failed")
+	}
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				if r := recover(); r != "loader failed" {
+					t.Fatalf("recovered %v, want the loader panic", r)
+				}
+			}()
+			s.GetOrComputeContext(context.Background(), "k", loader)
+		}()
+	}
+	if v, ok := s.Get("k"); ok {
+		t.Fatalf("got %v stored after a panic", v)
+	}
+}
+
+func TestGetOrComputeContextKeepsConcurrentSet(t *testing.T) {
+	s := NewSyncMap()
+	v, err := s.GetOrComputeContext(context.Background(), "k", func(ctx context.Context) (interface{}, error) {
+		s.Set("k", "set")
+		return "loaded", nil
+	})
+	if err != nil || v != "set" {
+		t.Fatalf("got %v, %v, want set", v, err)
+	}
+	if v, _ := s.Get("k"); v != "set" {
+		t.Fatalf("stored %v, want set", v)
+	}
+}
+
+func TestGetCopyNested(t *testing.T) {
+	s := NewSyncMap()
+	s.Set("m", map[string][]int{"a": {1}})
+	s.Set("n", 1)
+	v, _ := s.GetCopy("m")
+	v.(map[string][]int)["a"][0] = 100
+	v.(map[string][]int)["b"] = nil
+	if v, _ := s.Get("m"); v.(map[string][]int)["a"][0] != 1 || len(v.(map[string][]int)) != 1 {
+		t.Fatalf("stored map changed to %v", v)
+	}
+	if v, ok := s.GetCopy("n"); v != 1 || !ok {
+		t.Fatalf("got %v, %v, want 1, true", v, ok)
+	}
+	if v, ok := s.GetCopy("missing"); v != nil || ok {
+		t.Fatalf("got %v, %v for a missing key", v, ok)
+	}
+}
+
+func TestGetOrSetLocked(t *testing.T) {
+	s := NewSyncMap()
+	var wait sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				s.GetOrSetLocked("n", func(exists bool, old interface{}) (interface{}, bool) {
+					if !exists {
+						return 1, true
+					}
+					return old.(int) + 1, true
+				})
+			}
+		}()
+	}
+	wait.Wait()
+	if v, _ := s.Get("n"); v != 8000 {
+		t.Fatalf("got %v, want 8000", v)
+	}
+	s.GetOrSetLocked("n", func(exists bool, old interface{}) (interface{}, bool) {
+		return 0, false
+	})
+	if v, _ := s.Get("n"); v != 8000 {
+		t.Fatalf("compute declined to store, but got %v", v)
+	}
+}
+
+type saturatingIncrementer interface {
+	Map
+	SaturatingIncrement(key string, delta int64) (value int64, overflowed bool, err error)
+}
+
+func TestSaturatingIncrement(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []saturatingIncrementer{NewSyncMap(), g, g1} {
+		tests := []struct {
+			start, delta, want int64
+			overflowed         bool
+		}{
+			{0, 5, 5, false},
+			{math.MaxInt64 - 1, 1, math.MaxInt64, false},
+			{math.MaxInt64 - 1, 2, math.MaxInt64, true},
+			{math.MinInt64 + 1, -1, math.MinInt64, false},
+			{math.MinInt64 + 1, -2, math.MinInt64, true},
+		}
+		for _, tt := range tests {
+			m.Set("n", tt.start)
+			v, overflowed, err := m.SaturatingIncrement("n", tt.delta)
+			if v != tt.want || overflowed != tt.overflowed || err != nil {
+				t.Errorf("%T: %d%+d = %d, %v, %v, want %d, %v", m, tt.start, tt.delta, v, overflowed, err, tt.want, tt.overflowed)
+			}
+			if got, _ := m.Get("n"); got != tt.want {
+				t.Errorf("%T: stored %v, want %d", m, got, tt.want)
+			}
+		}
+		if v, _, err := m.SaturatingIncrement("missing", 3); v != 3 || err != nil {
+			t.Errorf("%T: missing key got %d, %v", m, v, err)
+		}
+		m.Set("s", "text")
+		if _, _, err := m.SaturatingIncrement("s", 1); err != ErrNotInt64 {
+			t.Errorf("%T: got %v, want ErrNotInt64", m, err)
+		}
+		if got, _ := m.Get("s"); got != "text" {
+			t.Errorf("%T: non-int64 value overwritten with %v", m, got)
+		}
+	}
+}
+
+func TestDone(t *testing.T) {
+	for _, m := range []interface {
+		Stop()
+		Done() <-chan struct{}
+	}{NewGoMap(), NewGoMap1Chan()} {
+		select {
+		case <-m.Done():
+			t.Fatalf("%T: Done closed before Stop", m)
+		default:
+		}
+		var wait sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				<-m.Done()
+			}()
+		}
+		m.Stop()
+		waitTimeout(t, 5*time.Second, wait.Wait)
+		if _, ok := <-m.Done(); ok {
+			t.Fatalf("%T: received a value from Done", m)
+		}
+	}
+}
+
+func TestContainsValue(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []interface {
+		Map
+		ContainsValue(value interface{}) bool
+	}{NewSyncMap(), g, g1} {
+		m.Set("a", 1)
+		m.Set("s", []int{1})
+		if !m.ContainsValue(1) {
+			t.Errorf("%T: present value not found", m)
+		}
+		if m.ContainsValue(2) {
+			t.Errorf("%T: absent value found", m)
+		}
+		if m.ContainsValue([]int{1}) {
+			t.Errorf("%T: uncomparable value found", m)
+		}
+	}
+}
+
+func TestGetOrSetFuncTimeout(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []interface {
+		Map
+		GetOrSetFuncTimeout(key string, fn func() interface{}, timeout time.Duration) (interface{}, bool, error)
+	}{g, g1} {
+		hang := make(chan struct{})
+		_, _, err := m.GetOrSetFuncTimeout("k", func() interface{} {
+			<-hang
+			return 1
+		}, 10*time.Millisecond)
+		close(hang)
+		if err != ErrCallbackTimeout {
+			t.Fatalf("%T: got %v, want ErrCallbackTimeout", m, err)
+		}
+		if v, ok := m.Get("k"); ok {
+			t.Fatalf("%T: abandoned result %v was stored", m, v)
+		}
+		m.Set("other", 2)
+		if v, _ := m.Get("other"); v != 2 {
+			t.Fatalf("%T: map unresponsive after timeout, got %v", m, v)
+		}
+		v, loaded, err := m.GetOrSetFuncTimeout("k", func() interface{} { return 3 }, time.Second)
+		if v != 3 || loaded || err != nil {
+			t.Fatalf("%T: got %v, %v, %v, want 3, false, nil", m, v, loaded, err)
+		}
+	}
+}
+
+// TestSyncMapStress is meant for go test -race: it hammers a SyncMap with
+// writes while whole-map reads run alongside.
+func TestSyncMapStress(t *testing.T) {
+	d := time.Second
+	if testing.Short() {
+		d = 100 * time.Millisecond
+	}
+	s := NewSyncMap()
+	stop := make(chan struct{})
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Snapshot()
+			s.ContainsValue(int64(0))
+		}
+	}()
+	ops := StressSyncMap(s, 32, d, 1)
+	close(stop)
+	wait.Wait()
+	if ops == 0 {
+		t.Fatal("no operations done")
+	}
+}
+
+func TestClaimPrefixDisjoint(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []interface {
+		Map
+		ClaimPrefix(prefix string, limit int) []MapEntry
+	}{NewSyncMap(), g, g1} {
+		const n = 1000
+		for i := 0; i < n; i++ {
+			m.Set("job/"+strconv.Itoa(i), i)
+		}
+		m.Set("other", 0)
+		var lock sync.Mutex
+		claimed := make(map[string]int)
+		var wait sync.WaitGroup
+		for w := 0; w < 2; w++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				for {
+					entries := m.ClaimPrefix("job/", 7)
+					if len(entries) == 0 {
+						return
+					}
+					if len(entries) > 7 {
+						t.Errorf("%T: claimed %d entries, limit is 7", m, len(entries))
+					}
+					lock.Lock()
+					for _, e := range entries {
+						claimed[e.Key]++
+					}
+					lock.Unlock()
+				}
+			}()
+		}
+		wait.Wait()
+		if len(claimed) != n {
+			t.Fatalf("%T: claimed %d keys, want %d", m, len(claimed), n)
+		}
+		for k, c := range claimed {
+			if c != 1 {
+				t.Fatalf("%T: %s claimed %d times", m, k, c)
+			}
+		}
+		if _, ok := m.Get("other"); !ok {
+			t.Fatalf("%T: claimed a key outside the prefix", m)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	g := NewGoMap()
+	g.Set("a", 1)
+	gc := g.Clone()
+	g1 := NewGoMap1Chan()
+	g1.Set("a", 1)
+	g1c := g1.Clone()
+	for _, pair := range [][2]loadAller{{g, gc}, {g1, g1c}} {
+		orig, clone := pair[0], pair[1]
+		orig.Set("a", 2)
+		clone.Set("b", 3)
+		if v, _ := clone.Get("a"); v != 1 {
+			t.Errorf("%T: clone sees the original's write: %v", clone, v)
+		}
+		if _, ok := orig.Get("b"); ok {
+			t.Errorf("%T: original sees the clone's write", orig)
+		}
+		waitTimeout(t, 5*time.Second, func() {
+			orig.Stop()
+			clone.Stop()
+		})
+	}
+}
+
+type bulkContexter interface {
+	exclusiveMap
+	Map
+	SetMultiContext(ctx context.Context, entries map[string]interface{}) error
+	GetMultiContext(ctx context.Context, keys []string) (map[string]interface{}, error)
+	DeleteMultiContext(ctx context.Context, keys []string) error
+	SnapshotContext(ctx context.Context) (map[string]interface{}, error)
+}
+
+func TestBulkContextBusyOwner(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []bulkContexter{g, g1} {
+		m.Set("k", 1)
+		// Park the owning goroutine so nothing can be dispatched.
+		_, _, release := m.exclusive()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		waitTimeout(t, 5*time.Second, func() {
+			if err := m.SetMultiContext(ctx, map[string]interface{}{"k": 2, "new": 3}); err != context.DeadlineExceeded {
+				t.Errorf("%T: SetMultiContext = %v", m, err)
+			}
+			if _, err := m.GetMultiContext(ctx, []string{"k"}); err != context.DeadlineExceeded {
+				t.Errorf("%T: GetMultiContext = %v", m, err)
+			}
+			if err := m.DeleteMultiContext(ctx, []string{"k"}); err != context.DeadlineExceeded {
+				t.Errorf("%T: DeleteMultiContext = %v", m, err)
+			}
+			if _, err := m.SnapshotContext(ctx); err != context.DeadlineExceeded {
+				t.Errorf("%T: SnapshotContext = %v", m, err)
+			}
+		})
+		cancel()
+		release()
+		snapshot, err := m.SnapshotContext(context.Background())
+		if err != nil || len(snapshot) != 1 || snapshot["k"] != 1 {
+			t.Fatalf("%T: canceled operations applied: %v, %v", m, snapshot, err)
+		}
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := m.SetMultiContext(canceled, map[string]interface{}{"k": 2}); err != context.Canceled {
+			t.Fatalf("%T: canceled context got %v", m, err)
+		}
+	}
+}
+
+func TestSetIfNewer(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []newerMap{NewSyncMap(), g, g1} {
+		// The same writes arriving in either order end with the newest.
+		for _, order := range [][]int64{{1, 2, 3}, {3, 1, 2}} {
+			key := fmt.Sprint(order)
+			for _, ts := range order {
+				wrote := m.SetIfNewer(key, ts, ts)
+				if v, _ := m.Get(key); wrote != (v == ts) {
+					t.Errorf("%T: write at %d reported %v, stored %v", m, ts, wrote, v)
+				}
+			}
+			if v, _ := m.Get(key); v != int64(3) {
+				t.Errorf("%T: order %v ended with %v, want 3", m, order, v)
+			}
+		}
+		if m.SetIfNewer("[1 2 3]", "same", 3) {
+			t.Errorf("%T: equal timestamp overwrote", m)
+		}
+	}
+}
+
+func TestWatchKey(t *testing.T) {
+	s := NewSyncMap()
+	c, stop := s.WatchKey("a")
+	other, stopOther := s.WatchKey("a")
+	s.Set("b", 1)
+	s.Set("a", 2)
+	s.Delete("b")
+	s.Delete("a")
+	stop()
+	stop()
+	s.Set("a", 3)
+
+	var got []KeyEvent
+	for e := range c {
+		got = append(got, e)
+	}
+	want := []KeyEvent{{"set", "a", 2}, {"delete", "a", nil}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The other watcher of the key still receives events.
+	if n := len(other); n != 3 {
+		t.Fatalf("other watcher has %d events, want 3", n)
+	}
+	stopOther()
+	s.lock.RLock()
+	n := len(s.watchers)
+	s.lock.RUnlock()
+	if n != 0 {
+		t.Fatalf("%d keys still watched after unsubscribing", n)
+	}
+}
+
+// recordingMap is a SyncMap that records the keys set on it.
+type recordingMap struct {
+	*SyncMap
+	lock sync.Mutex
+	keys []string
+}
+
+func (r *recordingMap) Set(key string, value interface{}) {
+	r.lock.Lock()
+	r.keys = append(r.keys, key)
+	r.lock.Unlock()
+	r.SyncMap.Set(key, value)
+}
+
+func TestSeedReproducible(t *testing.T) {
+	run := func(n int, seed int64) []string {
+		r := &recordingMap{SyncMap: NewSyncMap()}
+		TestInParallel(r, n, seed)
+		return r.keys
+	}
+	a, b := run(1, 42), run(1, 42)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("one worker with the same seed issued different sequences")
+	}
+	if reflect.DeepEqual(a, run(1, 43)) {
+		t.Fatal("different seeds issued the same sequence")
+	}
+	// Workers interleave differently from run to run, but together issue the
+	// same operations.
+	a, b = run(4, 42), run(4, 42)
+	sort.Strings(a)
+	sort.Strings(b)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("four workers with the same seed issued different operations")
+	}
+}
+
+func TestIsFlagSet(t *testing.T) {
+	for _, args := range [][]string{{"-seed", "0"}, {}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int64("seed", 0, "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if set := isFlagSet(fs, "seed"); set != (len(args) > 0) {
+			t.Errorf("%v: isFlagSet = %v", args, set)
+		}
+	}
+}
+
+func TestSetAndReport(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	for _, m := range []interface {
+		Map
+		SetAndReport(key string, value interface{}) bool
+	}{NewSyncMap(), g, g1} {
+		steps := []struct {
+			value   interface{}
+			changed bool
+		}{
+			{1, true},
+			{1, false},
+			{2, true},
+			{int64(2), true},
+			{[]int{1}, true},
+			{[]int{1}, true},
+		}
+		for _, s := range steps {
+			if changed := m.SetAndReport("k", s.value); changed != s.changed {
+				t.Errorf("%T: writing %v reported %v, want %v", m, s.value, changed, s.changed)
+			}
+		}
+	}
+}
+
+func TestBytesPerEntry(t *testing.T) {
+	const n = 10000
+	for _, m := range footprintMaps(n) {
+		// Each entry holds at least its key and value.
+		if b := BytesPerEntry(m.newMap, n); b < 16 || b > 4096 {
+			t.Errorf("%s %.1f bytes per entry", m.name, b)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+)
+
+var ErrMigrateUnsupported = errors.New("map does not support Migrate")
+
+// exclusiveMap is a map that can hand out sole access to its contents.
+// exclusive returns the contents and SetIfNewer timestamps, to be used until
+// release is called with the changes made. release returns nil or a function
+// delivering the change notifications, to be called once every map involved
+// is released.
+type exclusiveMap interface {
+	exclusive() (m map[string]interface{}, stamps map[string]int64, release func(changes ...mapChange) (notify func()))
+}
+
+// Migrate moves key from src to dst and reports whether src held it. The
+// move is atomic: both maps are held exclusively, taken in pointer order to
+// avoid deadlocks, so at any time the key is in exactly one of them. src and
+// dst must be SyncMaps, GoMaps or GoMap1Chans, otherwise
+// ErrMigrateUnsupported is returned and neither map is touched.
+func Migrate(src, dst Map, key string) (bool, error) {
+	s, ok := src.(exclusiveMap)
+	if !ok {
+		return false, ErrMigrateUnsupported
+	}
+	d, ok := dst.(exclusiveMap)
+	if !ok {
+		return false, ErrMigrateUnsupported
+	}
+	if src == dst {
+		_, ok := src.Get(key)
+		return ok, nil
+	}
+	var srcM, dstM map[string]interface{}
+	var srcStamps, dstStamps map[string]int64
+	var releaseSrc, releaseDst func(...mapChange) func()
+	if reflect.ValueOf(src).Pointer() < reflect.ValueOf(dst).Pointer() {
+		srcM, srcStamps, releaseSrc = s.exclusive()
+		dstM, dstStamps, releaseDst = d.exclusive()
+	} else {
+		dstM, dstStamps, releaseDst = d.exclusive()
+		srcM, srcStamps, releaseSrc = s.exclusive()
+	}
+
+	value, ok := srcM[key]
+	var srcChanges, dstChanges []mapChange
+	if ok {
+		delete(srcM, key)
+		dstM[key] = value
+		// The key carries its timestamp along, so dst keeps rejecting
+		// SetIfNewer writes older than the ones src accepted.
+		if stamp, ok := srcStamps[key]; ok {
+			dstStamps[key] = stamp
+			delete(srcStamps, key)
+		} else {
+			delete(dstStamps, key)
+		}
+		srcChanges = []mapChange{{"delete", key, nil}}
+		dstChanges = []mapChange{{"set", key, value}}
+	}
+	// Deliver the change notifications only once both maps are released,
+	// so hooks can use either map.
+	notifyDst := releaseDst(dstChanges...)
+	notifySrc := releaseSrc(srcChanges...)
+	if notifyDst != nil {
+		notifyDst()
+	}
+	if notifySrc != nil {
+		notifySrc()
+	}
+	return ok, nil
+}
+
+func (s *SyncMap) exclusive() (map[string]interface{}, map[string]int64, func(changes ...mapChange) func()) {
+	s.lock.Lock()
+	return s.m, s.stamps, func(changes ...mapChange) func() {
+		dispatch := s.changes.queue(changes, s.hooks, s.watchers)
+		s.lock.Unlock()
+		if dispatch {
+			return s.changes.dispatch
+		}
+		return nil
+	}
+}
+
+// exclusive parks the owning goroutine until release is called, so the
+// contents can be used from the calling goroutine. The owning goroutine
+// notifies the released changes itself, so release returns nil.
+func (g *GoMap) exclusive() (map[string]interface{}, map[string]int64, func(changes ...mapChange) func()) {
+	r := mapAcquire{make(chan struct{}), make(chan []mapChange)}
+	g.acquire <- r
+	<-r.acquired
+	return g.m, g.stamps, func(changes ...mapChange) func() {
+		r.release <- changes
+		return nil
+	}
+}
+
+// exclusive behaves like GoMap.exclusive.
+func (g *GoMap1Chan) exclusive() (map[string]interface{}, map[string]int64, func(changes ...mapChange) func()) {
+	r := mapAcquire{make(chan struct{}), make(chan []mapChange)}
+	g.in <- r
+	<-r.acquired
+	return g.m, g.stamps, func(changes ...mapChange) func() {
+		r.release <- changes
+		return nil
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	g, g1 := NewGoMap(), NewGoMap1Chan()
+	defer g.Stop()
+	defer g1.Stop()
+	maps := []Map{NewSyncMap(), g, g1, NewSyncMap()}
+	maps[0].Set("k", "v")
+	for i := 1; i < len(maps); i++ {
+		ok, err := Migrate(maps[i-1], maps[i], "k")
+		if !ok || err != nil {
+			t.Fatalf("Migrate to %T = %v, %v", maps[i], ok, err)
+		}
+		if _, ok := maps[i-1].Get("k"); ok {
+			t.Fatalf("key still in source %T", maps[i-1])
+		}
+		if v, _ := maps[i].Get("k"); v != "v" {
+			t.Fatalf("got %v in %T, want v", v, maps[i])
+		}
+	}
+	if ok, err := Migrate(maps[0], maps[1], "k"); ok || err != nil {
+		t.Fatalf("Migrate of absent key = %v, %v", ok, err)
+	}
+}
+
+func TestMigrateUnsupported(t *testing.T) {
+	s, o := NewSyncMap(), NewOrderedSyncMap()
+	s.Set("k", 1)
+	o.Set("k", 2)
+	if _, err := Migrate(s, o, "k"); err != ErrMigrateUnsupported {
+		t.Fatalf("got %v, want ErrMigrateUnsupported", err)
+	}
+	if _, err := Migrate(o, s, "k"); err != ErrMigrateUnsupported {
+		t.Fatalf("got %v, want ErrMigrateUnsupported", err)
+	}
+	if v, _ := s.Get("k"); v != 1 {
+		t.Fatalf("source changed to %v", v)
+	}
+}
+
+// TestMigrateAtomic moves a key back and forth while a reader holding both
+// maps checks that it is always in exactly one of them.
+func TestMigrateAtomic(t *testing.T) {
+	g := NewGoMap()
+	defer g.Stop()
+	a, b := Map(NewSyncMap()), Map(g)
+	a.Set("k", 1)
+	first, second := a.(exclusiveMap), b.(exclusiveMap)
+	if reflect.ValueOf(b).Pointer() < reflect.ValueOf(a).Pointer() {
+		first, second = second, first
+	}
+
+	stop := make(chan struct{})
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			src, dst := a, b
+			if i%2 == 1 {
+				src, dst = b, a
+			}
+			if ok, err := Migrate(src, dst, "k"); !ok || err != nil {
+				t.Errorf("Migrate = %v, %v", ok, err)
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		m1, _, release1 := first.exclusive()
+		m2, _, release2 := second.exclusive()
+		_, in1 := m1["k"]
+		_, in2 := m2["k"]
+		release2()
+		release1()
+		if in1 == in2 {
+			t.Fatalf("key in both or neither map: %v, %v", in1, in2)
+		}
+	}
+	close(stop)
+	wait.Wait()
+}
+
+func TestMigrateHooksUseOtherMap(t *testing.T) {
+	src, dst := NewSyncMap(), NewSyncMap()
+	src.Set("k", 1)
+	dst.OnChange(func(op, key string, value interface{}) {
+		src.Get(key)
+	})
+	src.OnChange(func(op, key string, value interface{}) {
+		dst.Get(key)
+	})
+	waitTimeout(t, 5*time.Second, func() {
+		Migrate(src, dst, "k")
+		Migrate(dst, src, "k")
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//////////////////////////////////// MIRRORING MAP DECORATOR //////////////////////////////////
+
+const mirrorBufferSize = 1024
+
+// mirrorLockStripes is the number of locks that order writes per key.
+const mirrorLockStripes = 64
+
+// mirrorLogInterval is the shortest time between two logs of dropped writes.
+const mirrorLogInterval = 10 * time.Second
+
+type deleter interface {
+	Delete(key string)
+}
+
+type mirrorOp struct {
+	del   bool
+	key   string
+	value interface{}
+}
+
+// MirrorMap applies writes to a primary map and replicates them to a
+// secondary map in the background, reading from the primary only. Writes
+// are queued for the secondary in a buffer; when the secondary falls so far
+// behind that the buffer is full, writes to it are dropped and counted,
+// with an occasional log, rather than slowing down the primary.
+type MirrorMap struct {
+	// dropped and lastLog are accessed atomically and come first to stay
+	// 64-bit aligned.
+	dropped uint64
+	lastLog int64
+
+	primary   Map
+	secondary Map
+	ops       chan mirrorOp
+	done      chan struct{}
+	// locks make each primary write and its enqueue one step, so the
+	// secondary sees the writes to a key in the order the primary applied
+	// them. Keys are spread over the locks so writers of different keys
+	// rarely wait for each other.
+	locks [mirrorLockStripes]sync.Mutex
+	// stopLock guards stopped and closing ops.
+	stopLock sync.RWMutex
+	stopped  bool
+}
+
+func Mirror(primary, secondary Map) *MirrorMap {
+	mm := &MirrorMap{
+		primary:   primary,
+		secondary: secondary,
+		ops:       make(chan mirrorOp, mirrorBufferSize),
+		done:      make(chan struct{}),
+	}
+	go mm.run()
+	return mm
+}
+
+func (mm *MirrorMap) run() {
+	defer close(mm.done)
+	for op := range mm.ops {
+		mm.apply(op)
+	}
+}
+
+// apply writes op to the secondary, logging instead of crashing if the
+// secondary panics.
+func (mm *MirrorMap) apply(op mirrorOp) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("mirror: writing %q to secondary failed: %v", op.key, r)
+		}
+	}()
+	if !op.del {
+		mm.secondary.Set(op.key, op.value)
+	} else if d, ok := mm.secondary.(deleter); ok {
+		d.Delete(op.key)
+	}
+}
+
+// keyLock returns the lock ordering the writes to key, picked by an FNV-1a
+// hash of key.
+func (mm *MirrorMap) keyLock(key string) *sync.Mutex {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &mm.locks[h%mirrorLockStripes]
+}
+
+// replicate queues op for the secondary and reports whether it had to drop
+// it. It is called with the lock of op.key held.
+func (mm *MirrorMap) replicate(op mirrorOp) bool {
+	mm.stopLock.RLock()
+	defer mm.stopLock.RUnlock()
+	if mm.stopped {
+		return false
+	}
+	select {
+	case mm.ops <- op:
+		return false
+	default:
+		atomic.AddUint64(&mm.dropped, 1)
+		return true
+	}
+}
+
+// logDropped logs how many writes were dropped so far, at most once per
+// mirrorLogInterval. It is called with no lock held.
+func (mm *MirrorMap) logDropped() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&mm.lastLog)
+	if now-last < int64(mirrorLogInterval) || !atomic.CompareAndSwapInt64(&mm.lastLog, last, now) {
+		return
+	}
+	log.Printf("mirror: secondary is falling behind, %d writes dropped so far", mm.Dropped())
+}
+
+// Dropped returns the number of writes dropped because the secondary fell
+// behind.
+func (mm *MirrorMap) Dropped() uint64 {
+	return atomic.LoadUint64(&mm.dropped)
+}
+
+// Stop waits for the queued writes to reach the secondary and stops
+// replicating: later writes only reach the primary. Neither backend is
+// stopped.
+func (mm *MirrorMap) Stop() {
+	mm.stopLock.Lock()
+	if !mm.stopped {
+		mm.stopped = true
+		close(mm.ops)
+	}
+	mm.stopLock.Unlock()
+	<-mm.done
+}
+
+func (mm *MirrorMap) Get(key string) (interface{}, bool) {
+	return mm.primary.Get(key)
+}
+
+func (mm *MirrorMap) Set(key string, value interface{}) {
+	lock := mm.keyLock(key)
+	lock.Lock()
+	mm.primary.Set(key, value)
+	dropped := mm.replicate(mirrorOp{key: key, value: value})
+	lock.Unlock()
+	if dropped {
+		mm.logDropped()
+	}
+}
+
+// Delete deletes key from both maps, on those that support deleting.
+func (mm *MirrorMap) Delete(key string) {
+	lock := mm.keyLock(key)
+	lock.Lock()
+	if d, ok := mm.primary.(deleter); ok {
+		d.Delete(key)
+	}
+	dropped := mm.replicate(mirrorOp{del: true, key: key})
+	lock.Unlock()
+	if dropped {
+		mm.logDropped()
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowMap is a SyncMap whose writes can be held up.
+type slowMap struct {
+	*SyncMap
+	hold chan struct{}
+}
+
+func (s *slowMap) Set(key string, value interface{}) {
+	<-s.hold
+	s.SyncMap.Set(key, value)
+}
+
+func TestMirrorPropagates(t *testing.T) {
+	primary, secondary := NewSyncMap(), NewSyncMap()
+	mm := Mirror(primary, secondary)
+	mm.Set("a", 1)
+	mm.Set("b", 2)
+	mm.Delete("a")
+	mm.Stop()
+	if _, ok := secondary.Get("a"); ok {
+		t.Fatal("delete not mirrored")
+	}
+	if v, _ := secondary.Get("b"); v != 2 {
+		t.Fatalf("secondary has %v, want 2", v)
+	}
+}
+
+// TestMirrorOrder writes the same key from several goroutines and checks
+// the secondary ends up agreeing with the primary.
+func TestMirrorOrder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		primary, secondary := NewSyncMap(), NewSyncMap()
+		mm := Mirror(primary, secondary)
+		var wait sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wait.Add(1)
+			go func(g int) {
+				defer wait.Done()
+				for j := 0; j < 100; j++ {
+					mm.Set("k", strconv.Itoa(g))
+				}
+			}(g)
+		}
+		wait.Wait()
+		mm.Stop()
+		p, _ := primary.Get("k")
+		if s, _ := secondary.Get("k"); s != p {
+			t.Fatalf("secondary has %v, primary %v", s, p)
+		}
+	}
+}
+
+func TestMirrorSlowSecondary(t *testing.T) {
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	secondary := &slowMap{NewSyncMap(), make(chan struct{})}
+	mm := Mirror(NewSyncMap(), secondary)
+	const n = 2 * mirrorBufferSize
+	waitTimeout(t, 5*time.Second, func() {
+		for i := 0; i < n; i++ {
+			mm.Set(strconv.Itoa(i), i)
+		}
+	})
+	close(secondary.hold)
+	mm.Stop()
+	if v, ok := mm.Get("0"); !ok || v != 0 {
+		t.Fatalf("primary has %v, %v", v, ok)
+	}
+	// The run goroutine holds one write while the buffer fills up.
+	if d := mm.Dropped(); d < n-mirrorBufferSize-1 || d > n-mirrorBufferSize {
+		t.Fatalf("dropped %d writes, want about %d", d, n-mirrorBufferSize)
+	}
+	if lines := strings.Count(logged.String(), "\n"); lines != 1 {
+		t.Fatalf("logged %d lines, want 1:\n%s", lines, logged.String())
+	}
+}
+
+func TestMirrorWriteAfterStop(t *testing.T) {
+	primary, secondary := NewSyncMap(), NewSyncMap()
+	mm := Mirror(primary, secondary)
+	mm.Stop()
+	mm.Stop()
+	mm.Set("k", 1)
+	mm.Delete("k")
+	mm.Set("k", 2)
+	if v, _ := primary.Get("k"); v != 2 {
+		t.Fatalf("primary has %v, want 2", v)
+	}
+	if _, ok := secondary.Get("k"); ok {
+		t.Fatal("write after Stop reached the secondary")
+	}
+}
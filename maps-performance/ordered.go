@@ -0,0 +1,157 @@
+package main
+
+import "sync"
+
+//////////////////////////////// INSERTION ORDERED SYNC MAP //////////////////////////////
+
+// OrderedSyncMap is a SyncMap that remembers the order in which keys were
+// first set. Updating an existing key keeps its position, deleting it
+// removes it from the order.
+//
+// Deletes only mark their slot in the order as dead; the order is compacted
+// once dead slots outnumber live ones, so deleting stays cheap and iterating
+// stays proportional to the number of live entries.
+type OrderedSyncMap struct {
+	lock  sync.RWMutex
+	m     map[string]orderedValue
+	order []orderedSlot
+	dead  int
+}
+
+type orderedValue struct {
+	value interface{}
+	pos   int
+}
+
+type orderedSlot struct {
+	key  string
+	live bool
+}
+
+func NewOrderedSyncMap() *OrderedSyncMap {
+	return NewOrderedSyncMapSize(0)
+}
+
+func NewOrderedSyncMapSize(n int) *OrderedSyncMap {
+	return &OrderedSyncMap{m: make(map[string]orderedValue, n), order: make([]orderedSlot, 0, n)}
+}
+
+func (s *OrderedSyncMap) Get(key string) (interface{}, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, ok := s.m[key]
+	return v.value, ok
+}
+
+func (s *OrderedSyncMap) Set(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.m[key]; ok {
+		s.m[key] = orderedValue{value, v.pos}
+		return
+	}
+	s.m[key] = orderedValue{value, len(s.order)}
+	s.order = append(s.order, orderedSlot{key, true})
+}
+
+func (s *OrderedSyncMap) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.m[key]; ok {
+		s.delete(key, v.pos)
+	}
+}
+
+func (s *OrderedSyncMap) delete(key string, pos int) {
+	delete(s.m, key)
+	s.order[pos].live = false
+	s.dead++
+	if s.dead > len(s.order)/2 {
+		s.compact()
+	}
+}
+
+func (s *OrderedSyncMap) LoadAndDelete(key string) (interface{}, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, ok := s.m[key]
+	if ok {
+		s.delete(key, v.pos)
+	}
+	return v.value, ok
+}
+
+// Compact drops the slots of deleted keys from the insertion order right
+// away instead of waiting for them to outnumber the live ones.
+func (s *OrderedSyncMap) Compact() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compact()
+}
+
+func (s *OrderedSyncMap) compact() {
+	if s.dead == 0 {
+		return
+	}
+	order := make([]orderedSlot, 0, len(s.m))
+	for _, slot := range s.order {
+		if slot.live {
+			s.m[slot.key] = orderedValue{s.m[slot.key].value, len(order)}
+			order = append(order, slot)
+		}
+	}
+	s.order = order
+	s.dead = 0
+}
+
+func (s *OrderedSyncMap) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.m)
+}
+
+func (s *OrderedSyncMap) Keys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := make([]string, 0, len(s.m))
+	for _, slot := range s.order {
+		if slot.live {
+			keys = append(keys, slot.key)
+		}
+	}
+	return keys
+}
+
+func (s *OrderedSyncMap) Entries() []MapEntry {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	entries := make([]MapEntry, 0, len(s.m))
+	for _, slot := range s.order {
+		if slot.live {
+			entries = append(entries, MapEntry{slot.key, s.m[slot.key].value})
+		}
+	}
+	return entries
+}
+
+// Snapshot returns a copy of the map, without the order, taken under the
+// read lock.
+func (s *OrderedSyncMap) Snapshot() map[string]interface{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	m := make(map[string]interface{}, len(s.m))
+	for k, v := range s.m {
+		m[k] = v.value
+	}
+	return m
+}
+
+// Range calls fn for each entry in insertion order until fn returns false.
+// It works on a snapshot, so fn may safely call back into the map.
+func (s *OrderedSyncMap) Range(fn func(key string, value interface{}) bool) {
+	for _, e := range s.Entries() {
+		if !fn(e.Key, e.Value) {
+			return
+		}
+	}
+}
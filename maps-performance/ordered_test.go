@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestOrderedSyncMapOrder(t *testing.T) {
+	s := NewOrderedSyncMap()
+	s.Set("c", 1)
+	s.Set("a", 2)
+	s.Set("b", 3)
+	s.Set("c", 4)
+	s.Delete("a")
+	s.Set("a", 5)
+	s.Delete("missing")
+
+	if got, want := s.Keys(), []string{"c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keys = %v, want %v", got, want)
+	}
+	want := []MapEntry{{"c", 4}, {"b", 3}, {"a", 5}}
+	if got := s.Entries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Entries = %v, want %v", got, want)
+	}
+	var ranged []MapEntry
+	s.Range(func(key string, value interface{}) bool {
+		ranged = append(ranged, MapEntry{key, value})
+		return true
+	})
+	if !reflect.DeepEqual(ranged, want) {
+		t.Fatalf("Range = %v, want %v", ranged, want)
+	}
+}
+
+func TestOrderedSyncMapCompaction(t *testing.T) {
+	s := NewOrderedSyncMap()
+	for i := 0; i < 1000; i++ {
+		s.Set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 1000; i++ {
+		if i%10 != 0 {
+			s.Delete(strconv.Itoa(i))
+		}
+	}
+	keys := s.Keys()
+	if len(keys) != 100 {
+		t.Fatalf("got %d keys, want 100", len(keys))
+	}
+	for i, k := range keys {
+		if k != strconv.Itoa(i*10) {
+			t.Fatalf("key %d is %s, want %d", i, k, i*10)
+		}
+	}
+	// Dead slots never outnumber live ones.
+	if n := len(s.order); n > 2*s.Len() {
+		t.Fatalf("order holds %d slots for %d live keys", n, s.Len())
+	}
+	s.Delete("0")
+	s.Compact()
+	if n := len(s.order); n != s.Len() {
+		t.Fatalf("order holds %d slots after Compact, want %d", n, s.Len())
+	}
+}
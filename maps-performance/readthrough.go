@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////// READ-THROUGH CACHE MAP //////////////////////////////////
+
+var errNotFound = errors.New("key not found")
+
+// missSweepInterval is the number of misses recorded between two sweeps of
+// the expired ones.
+const missSweepInterval = 1024
+
+// ReadThroughMap is a SyncMap backed by a loader: a Get that misses calls
+// the loader, caches what it finds and returns it. Concurrent misses for
+// the same key share a single loader call; if it panics, the panic is raised
+// again in each of them.
+type ReadThroughMap struct {
+	cache  *SyncMap
+	loader func(key string) (interface{}, bool)
+	flight flightGroup
+
+	lock        sync.Mutex
+	negativeTTL time.Duration
+	misses      map[string]time.Time
+	// recorded counts the misses recorded since the last sweep.
+	recorded int
+}
+
+func NewReadThroughMap(loader func(key string) (interface{}, bool)) *ReadThroughMap {
+	return &ReadThroughMap{
+		cache:  NewSyncMap(),
+		loader: loader,
+		misses: make(map[string]time.Time),
+	}
+}
+
+// SetNegativeTTL makes the map remember, for ttl, the keys the loader
+// didn't find, so Gets for them don't call the loader again until then.
+// A ttl of zero, the default, disables negative caching.
+func (r *ReadThroughMap) SetNegativeTTL(ttl time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.negativeTTL = ttl
+}
+
+func (r *ReadThroughMap) Get(key string) (interface{}, bool) {
+	if value, ok := r.cache.Get(key); ok {
+		return value, true
+	}
+	if r.knownMiss(key) {
+		return nil, false
+	}
+	c := r.flight.do(key, func() (interface{}, error) {
+		// A call that just finished may have cached the key already.
+		if value, ok := r.cache.Get(key); ok {
+			return value, nil
+		}
+		value, ok := r.loader(key)
+		if !ok {
+			r.recordMiss(key)
+			return nil, errNotFound
+		}
+		// Don't clobber a value Set while the loader ran.
+		value, _ = r.cache.GetOrSetFunc(key, func() interface{} { return value })
+		return value, nil
+	})
+	<-c.done
+	value, err := c.result()
+	return value, err == nil
+}
+
+func (r *ReadThroughMap) Set(key string, value interface{}) {
+	r.lock.Lock()
+	delete(r.misses, key)
+	r.lock.Unlock()
+	r.cache.Set(key, value)
+}
+
+// Delete drops key from the cache, so the next Get loads it again.
+func (r *ReadThroughMap) Delete(key string) {
+	r.lock.Lock()
+	delete(r.misses, key)
+	r.lock.Unlock()
+	r.cache.Delete(key)
+}
+
+func (r *ReadThroughMap) knownMiss(key string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	expires, ok := r.misses[key]
+	if ok && !time.Now().Before(expires) {
+		delete(r.misses, key)
+		return false
+	}
+	return ok
+}
+
+// recordMiss remembers that key wasn't found. Every missSweepInterval
+// misses, the expired ones are dropped, so a stream of distinct missing keys
+// doesn't grow the map past those seen within the TTL.
+func (r *ReadThroughMap) recordMiss(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.negativeTTL <= 0 {
+		return
+	}
+	now := time.Now()
+	r.misses[key] = now.Add(r.negativeTTL)
+	r.recorded++
+	if r.recorded < missSweepInterval {
+		return
+	}
+	r.recorded = 0
+	for k, expires := range r.misses {
+		if !now.Before(expires) {
+			delete(r.misses, k)
+		}
+	}
+}
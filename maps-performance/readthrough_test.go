@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThroughMapLoadsOnce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	r := NewReadThroughMap(func(key string) (interface{}, bool) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "loaded " + key, true
+	})
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if v, ok := r.Get("k"); v != "loaded k" || !ok {
+				t.Errorf("got %v, %v", v, ok)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wait.Wait()
+	for i := 0; i < 3; i++ {
+		r.Get("k")
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times, want 1", calls)
+	}
+}
+
+func TestReadThroughMapNegativeTTL(t *testing.T) {
+	var calls int32
+	r := NewReadThroughMap(func(key string) (interface{}, bool) {
+		atomic.AddInt32(&calls, 1)
+		return nil, false
+	})
+	r.Get("k")
+	r.Get("k")
+	if calls != 2 {
+		t.Fatalf("without negative caching the loader was called %d times, want 2", calls)
+	}
+	r.SetNegativeTTL(20 * time.Millisecond)
+	r.Get("k")
+	r.Get("k")
+	if calls != 3 {
+		t.Fatalf("loader called %d times, want the miss cached", calls)
+	}
+	time.Sleep(30 * time.Millisecond)
+	r.Get("k")
+	if calls != 4 {
+		t.Fatalf("loader called %d times, want the miss expired", calls)
+	}
+	r.Set("k", 1)
+	if v, ok := r.Get("k"); v != 1 || !ok {
+		t.Fatalf("Set after a miss got %v, %v", v, ok)
+	}
+}
+
+func TestReadThroughMapKeepsConcurrentSet(t *testing.T) {
+	var r *ReadThroughMap
+	r = NewReadThroughMap(func(key string) (interface{}, bool) {
+		r.Set(key, "set")
+		return "loaded", true
+	})
+	if v, _ := r.Get("k"); v != "set" {
+		t.Fatalf("got %v, want the value Set while loading", v)
+	}
+	if v, _ := r.Get("k"); v != "set" {
+		t.Fatalf("cached %v, want set", v)
+	}
+}
+
+func TestReadThroughMapSweepsMisses(t *testing.T) {
+	r := NewReadThroughMap(func(key string) (interface{}, bool) {
+		return nil, false
+	})
+	r.SetNegativeTTL(time.Millisecond)
+	for i := 0; i < 10*missSweepInterval; i++ {
+		r.Get(strconv.Itoa(i))
+		if i%missSweepInterval == 0 {
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	r.lock.Lock()
+	n := len(r.misses)
+	r.lock.Unlock()
+	if n > 2*missSweepInterval {
+		t.Fatalf("%d misses remembered, want expired ones swept", n)
+	}
+}
+
+func TestReadThroughMapLoaderPanic(t *testing.T) {
+	release := make(chan struct{})
+	r := NewReadThroughMap(func(key string) (interface{}, bool) {
+		<-release
+		panic("loader failed")
+	})
+	var wait sync.WaitGroup
+	var recovered int32
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			defer func() {
+				if recover() == "loader failed" {
+					atomic.AddInt32(&recovered, 1)
+				}
+			}()
+			r.Get("k")
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	waitTimeout(t, 5*time.Second, wait.Wait)
+	if recovered != 4 {
+		t.Fatalf("%d callers got the panic, want 4", recovered)
+	}
+}
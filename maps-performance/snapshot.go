@@ -0,0 +1,29 @@
+package main
+
+import "errors"
+
+var ErrSnapshotUnsupported = errors.New("map does not support Snapshot")
+
+type snapshotter interface {
+	Snapshot() map[string]interface{}
+}
+
+// SnapshotAll returns a snapshot of each of maps, in argument order. Each
+// snapshot is consistent on its own, taken in a single step by its map, but
+// the maps are snapshotted one after the other in argument order, so changes
+// made meanwhile may show in later snapshots and not in earlier ones. No two
+// maps are locked at once, so maps whose values or hooks reach into each
+// other can't deadlock. If any of maps has no Snapshot method,
+// ErrSnapshotUnsupported is returned and no map is snapshotted.
+func SnapshotAll(maps ...Map) ([]map[string]interface{}, error) {
+	for _, m := range maps {
+		if _, ok := m.(snapshotter); !ok {
+			return nil, ErrSnapshotUnsupported
+		}
+	}
+	snapshots := make([]map[string]interface{}, len(maps))
+	for i, m := range maps {
+		snapshots[i] = m.(snapshotter).Snapshot()
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotAll(t *testing.T) {
+	g := NewGoMap()
+	defer g.Stop()
+	s, o, typed := NewSyncMap(), NewOrderedSyncMap(), NewTypedMap()
+	g.Set("g", 1)
+	s.Set("s", 2)
+	o.Set("o", 3)
+	typed.Set("t", 4)
+	if _, err := SnapshotAll(g, s, typed); err != ErrSnapshotUnsupported {
+		t.Fatalf("got %v, want ErrSnapshotUnsupported", err)
+	}
+	snapshots, err := SnapshotAll(g, s, o)
+	want := []map[string]interface{}{{"g": 1}, {"s": 2}, {"o": 3}}
+	if err != nil || !reflect.DeepEqual(snapshots, want) {
+		t.Fatalf("got %v, %v, want %v", snapshots, err, want)
+	}
+	snapshots[1]["s"] = 20
+	if v, _ := s.Get("s"); v != 2 {
+		t.Fatal("snapshot shares storage with its map")
+	}
+}
+
+// TestSnapshotAllCrossWrites snapshots two maps whose hooks write into each
+// other while they are being written.
+func TestSnapshotAllCrossWrites(t *testing.T) {
+	a, b := NewSyncMap(), NewSyncMap()
+	a.OnChange(func(op, key string, value interface{}) {
+		if op == "set" {
+			b.Set(key, value)
+		}
+	})
+	b.OnChange(func(op, key string, value interface{}) {
+		SnapshotAll(a, b)
+	})
+	stop := make(chan struct{})
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			a.Set("k", i)
+		}
+	}()
+	waitTimeout(t, 5*time.Second, func() {
+		for i := 0; i < 1000; i++ {
+			SnapshotAll(b, a)
+		}
+	})
+	close(stop)
+	wait.Wait()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+//////////////////////////////////// TYPED VALUE MAP //////////////////////////////////
+
+type ValueKind uint8
+
+const (
+	KindNone ValueKind = iota
+	KindInt
+	KindFloat
+	KindString
+	KindOther
+)
+
+// TypedValue holds int64, float64 and string values unboxed, so storing
+// them doesn't allocate. Anything else falls back to Other.
+type TypedValue struct {
+	Kind  ValueKind
+	Int   int64
+	Float float64
+	Str   string
+	Other interface{}
+}
+
+// Value returns the held value as an interface{}.
+func (v TypedValue) Value() interface{} {
+	switch v.Kind {
+	case KindInt:
+		return v.Int
+	case KindFloat:
+		return v.Float
+	case KindString:
+		return v.Str
+	}
+	return v.Other
+}
+
+// TypedMap is a SyncMap storing TypedValues, with typed setters and getters
+// that avoid interface{} boxing for scalar values.
+type TypedMap struct {
+	lock sync.RWMutex
+	m    map[string]TypedValue
+}
+
+func NewTypedMap() *TypedMap {
+	return &TypedMap{m: make(map[string]TypedValue)}
+}
+
+func (t *TypedMap) Get(key string) (interface{}, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	v, ok := t.m[key]
+	return v.Value(), ok
+}
+
+// Set stores value, unboxed if it is a string, a float or an integer that
+// fits in an int64. Integers and floats are widened, so Get and GetInt or
+// GetFloat return them as int64 or float64.
+func (t *TypedMap) Set(key string, value interface{}) {
+	var v TypedValue
+	switch value := value.(type) {
+	case int:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case int8:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case int16:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case int32:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case int64:
+		v = TypedValue{Kind: KindInt, Int: value}
+	case uint8:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case uint16:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case uint32:
+		v = TypedValue{Kind: KindInt, Int: int64(value)}
+	case uint:
+		if uint64(value) > math.MaxInt64 {
+			v = TypedValue{Kind: KindOther, Other: value}
+		} else {
+			v = TypedValue{Kind: KindInt, Int: int64(value)}
+		}
+	case uint64:
+		if value > math.MaxInt64 {
+			v = TypedValue{Kind: KindOther, Other: value}
+		} else {
+			v = TypedValue{Kind: KindInt, Int: int64(value)}
+		}
+	case float32:
+		v = TypedValue{Kind: KindFloat, Float: float64(value)}
+	case float64:
+		v = TypedValue{Kind: KindFloat, Float: value}
+	case string:
+		v = TypedValue{Kind: KindString, Str: value}
+	default:
+		v = TypedValue{Kind: KindOther, Other: value}
+	}
+	t.store(key, v)
+}
+
+func (t *TypedMap) store(key string, v TypedValue) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.m[key] = v
+}
+
+func (t *TypedMap) load(key string, kind ValueKind) (TypedValue, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	v, ok := t.m[key]
+	return v, ok && v.Kind == kind
+}
+
+func (t *TypedMap) SetInt(key string, value int64) {
+	t.store(key, TypedValue{Kind: KindInt, Int: value})
+}
+
+// GetInt returns the int64 stored at key. ok is false if the key is missing
+// or holds another kind of value.
+func (t *TypedMap) GetInt(key string) (int64, bool) {
+	v, ok := t.load(key, KindInt)
+	return v.Int, ok
+}
+
+func (t *TypedMap) SetFloat(key string, value float64) {
+	t.store(key, TypedValue{Kind: KindFloat, Float: value})
+}
+
+func (t *TypedMap) GetFloat(key string) (float64, bool) {
+	v, ok := t.load(key, KindFloat)
+	return v.Float, ok
+}
+
+func (t *TypedMap) SetString(key string, value string) {
+	t.store(key, TypedValue{Kind: KindString, Str: value})
+}
+
+func (t *TypedMap) GetString(key string) (string, bool) {
+	v, ok := t.load(key, KindString)
+	return v.Str, ok
+}
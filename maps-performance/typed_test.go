@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestTypedMap(t *testing.T) {
+	m := NewTypedMap()
+	m.SetInt("i", 1)
+	m.SetFloat("f", 1.5)
+	m.SetString("s", "x")
+	m.Set("o", []int{1})
+	if v, ok := m.GetInt("i"); v != 1 || !ok {
+		t.Errorf("GetInt = %v, %v", v, ok)
+	}
+	if v, ok := m.GetFloat("f"); v != 1.5 || !ok {
+		t.Errorf("GetFloat = %v, %v", v, ok)
+	}
+	if v, ok := m.GetString("s"); v != "x" || !ok {
+		t.Errorf("GetString = %v, %v", v, ok)
+	}
+	if _, ok := m.GetInt("s"); ok {
+		t.Error("GetInt read a string")
+	}
+	if v, _ := m.Get("i"); v != int64(1) {
+		t.Errorf("Get = %#v, want int64(1)", v)
+	}
+	m.Set("i", int64(2))
+	if v, ok := m.GetInt("i"); v != 2 || !ok {
+		t.Errorf("Set of an int64 not readable by GetInt: %v, %v", v, ok)
+	}
+}
+
+func TestTypedMapSetClassifiesNumbers(t *testing.T) {
+	m := NewTypedMap()
+	ints := map[string]interface{}{
+		"int": 1, "int8": int8(1), "int16": int16(1), "int32": int32(1),
+		"uint": uint(1), "uint8": uint8(1), "uint16": uint16(1), "uint32": uint32(1), "uint64": uint64(1),
+	}
+	for key, value := range ints {
+		m.Set(key, value)
+		if v, ok := m.GetInt(key); v != 1 || !ok {
+			t.Errorf("%s: GetInt = %v, %v", key, v, ok)
+		}
+	}
+	m.Set("float32", float32(1.5))
+	if v, ok := m.GetFloat("float32"); v != 1.5 || !ok {
+		t.Errorf("float32: GetFloat = %v, %v", v, ok)
+	}
+	m.Set("big", uint64(math.MaxUint64))
+	if v, _ := m.Get("big"); v != uint64(math.MaxUint64) {
+		t.Errorf("uint64 overflowing int64 stored as %#v", v)
+	}
+}
+
+func TestTypedMapSetIntDoesNotAllocate(t *testing.T) {
+	m := NewTypedMap()
+	m.SetInt("k", 0)
+	n := int64(1 << 40)
+	if allocs := testing.AllocsPerRun(100, func() {
+		n++
+		m.SetInt("k", n)
+	}); allocs != 0 {
+		t.Fatalf("SetInt made %v allocations", allocs)
+	}
+}
+
+func BenchmarkIntSetGet(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.Run("SyncMap", func(b *testing.B) {
+		b.ReportAllocs()
+		s := NewSyncMap()
+		for i := 0; i < b.N; i++ {
+			k := keys[i%len(keys)]
+			s.Set(k, int64(i)<<20)
+			s.Get(k)
+		}
+	})
+	b.Run("TypedMap", func(b *testing.B) {
+		b.ReportAllocs()
+		m := NewTypedMap()
+		for i := 0; i < b.N; i++ {
+			k := keys[i%len(keys)]
+			m.SetInt(k, int64(i)<<20)
+			m.GetInt(k)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"reflect"
+	"sort"
+)
+
+// MergeSlices returns a new concurrent slice holding the items of all the
+// given slices concatenated in argument order. The read locks of all sources
+// are held together, taken in pointer order to avoid deadlocks, so the
+// result is a consistent snapshot of every source.
+func MergeSlices(slices ...*ConcurrentSlice) *ConcurrentSlice {
+	locked := make([]*ConcurrentSlice, 0, len(slices))
+	seen := make(map[*ConcurrentSlice]bool)
+	for _, s := range slices {
+		if !seen[s] {
+			seen[s] = true
+			locked = append(locked, s)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool {
+		return reflect.ValueOf(locked[i]).Pointer() < reflect.ValueOf(locked[j]).Pointer()
+	})
+	for _, s := range locked {
+		s.RLock()
+	}
+	defer func() {
+		for _, s := range locked {
+			s.RUnlock()
+		}
+	}()
+
+	n := 0
+	for _, s := range slices {
+		n += len(s.items)
+	}
+	merged := &ConcurrentSlice{
+		items: make([]interface{}, 0, n),
+		ids:   make([]uint64, 0, n),
+	}
+	for _, s := range slices {
+		merged.push(s.items...)
+	}
+
+	return merged
+}
+
+// MergeSorted returns a new concurrent slice holding the items of a and b,
+// which must both be sorted by less, merged in sorted order. Each source is
+// snapshotted under its own read lock. On ties items from a come first, so
+// the merge is stable.
+func MergeSorted(a, b *ConcurrentSlice, less func(x, y interface{}) bool) *ConcurrentSlice {
+	a.RLock()
+	left := make([]interface{}, len(a.items))
+	copy(left, a.items)
+	a.RUnlock()
+
+	b.RLock()
+	right := make([]interface{}, len(b.items))
+	copy(right, b.items)
+	b.RUnlock()
+
+	items := make([]interface{}, 0, len(left)+len(right))
+	i, j := 0, 0
+	for i < len(left) && j < len(right) {
+		if less(right[j], left[i]) {
+			items = append(items, right[j])
+			j++
+		} else {
+			items = append(items, left[i])
+			i++
+		}
+	}
+	items = append(items, left[i:]...)
+	items = append(items, right[j:]...)
+
+	merged := &ConcurrentSlice{}
+	merged.reset(items)
+	return merged
+}
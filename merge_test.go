@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeSorted(t *testing.T) {
+	less := func(x, y interface{}) bool { return x.(int) < y.(int) }
+	tests := []struct {
+		a, b, want []interface{}
+	}{
+		{[]interface{}{1, 4, 6}, []interface{}{2, 3, 7, 8}, []interface{}{1, 2, 3, 4, 6, 7, 8}},
+		{[]interface{}{}, []interface{}{1, 2}, []interface{}{1, 2}},
+		{[]interface{}{1, 2}, []interface{}{}, []interface{}{1, 2}},
+		{[]interface{}{}, []interface{}{}, []interface{}{}},
+	}
+	for _, tt := range tests {
+		got := contents(MergeSorted(newSlice(tt.a...), newSlice(tt.b...), less))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("merging %v and %v = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMergeSortedStable(t *testing.T) {
+	type item struct {
+		key  int
+		from string
+	}
+	less := func(x, y interface{}) bool { return x.(item).key < y.(item).key }
+	a := newSlice(item{1, "a"}, item{2, "a"})
+	b := newSlice(item{1, "b"}, item{2, "b"})
+	want := []interface{}{item{1, "a"}, item{1, "b"}, item{2, "a"}, item{2, "b"}}
+	if got := contents(MergeSorted(a, b, less)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The merged slice works like any other.
+	merged := MergeSorted(a, b, less)
+	merged.Append(item{3, "c"})
+	if n := len(contents(merged)); n != 5 {
+		t.Fatalf("merged slice has %d items after Append, want 5", n)
+	}
+}
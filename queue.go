@@ -0,0 +1,104 @@
+package utils
+
+import "sync"
+
+const minQueueCapacity = 16
+
+// Queue is a FIFO queue of typed items that can be safely shared between
+// goroutines. It is backed by a ring buffer that grows when full and
+// shrinks when mostly empty.
+type Queue[T any] struct {
+	sync.Mutex
+	buf  []T
+	head int
+	size int
+
+	onResize func(oldCap, newCap int)
+}
+
+// NewQueue creates a new queue.
+func NewQueue[T any]() *Queue[T] {
+	q := &Queue[T]{
+		buf: make([]T, minQueueCapacity),
+	}
+
+	return q
+}
+
+// OnResize registers fn to be called, outside the lock, whenever the queue
+// grows or shrinks its backing buffer, with the capacities before and after.
+// Calls from concurrent resizes may arrive out of order. A nil fn removes
+// the callback.
+func (q *Queue[T]) OnResize(fn func(oldCap, newCap int)) {
+	q.Lock()
+	defer q.Unlock()
+	q.onResize = fn
+}
+
+// Enqueue adds an item to the back of the queue.
+func (q *Queue[T]) Enqueue(item T) {
+	q.Lock()
+	oldCap := len(q.buf)
+	if q.size == len(q.buf) {
+		q.resize(2 * len(q.buf))
+	}
+	q.buf[(q.head+q.size)%len(q.buf)] = item
+	q.size++
+	newCap, fn := len(q.buf), q.onResize
+	q.Unlock()
+	if fn != nil && newCap != oldCap {
+		fn(oldCap, newCap)
+	}
+}
+
+// Dequeue removes and returns the item at the front of the queue.
+func (q *Queue[T]) Dequeue() (T, bool) {
+	q.Lock()
+	var zero T
+	if q.size == 0 {
+		q.Unlock()
+		return zero, false
+	}
+	item := q.buf[q.head]
+	q.buf[q.head] = zero
+	q.head = (q.head + 1) % len(q.buf)
+	q.size--
+	oldCap := len(q.buf)
+	if len(q.buf) > minQueueCapacity && q.size <= len(q.buf)/4 {
+		q.resize(len(q.buf) / 2)
+	}
+	newCap, fn := len(q.buf), q.onResize
+	q.Unlock()
+	if fn != nil && newCap != oldCap {
+		fn(oldCap, newCap)
+	}
+	return item, true
+}
+
+// Peek returns the item at the front of the queue without removing it.
+func (q *Queue[T]) Peek() (T, bool) {
+	q.Lock()
+	defer q.Unlock()
+	if q.size == 0 {
+		var zero T
+		return zero, false
+	}
+	return q.buf[q.head], true
+}
+
+// Len returns the number of items in the queue.
+func (q *Queue[T]) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.size
+}
+
+func (q *Queue[T]) resize(capacity int) {
+	buf := make([]T, capacity)
+	n := copy(buf, q.buf[q.head:])
+	if n < q.size {
+		copy(buf[n:], q.buf[:q.size-n])
+	}
+	q.buf = buf
+	q.head = 0
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestQueueFIFO(t *testing.T) {
+	q := NewQueue[int]()
+	// Enough items to grow and wrap the ring buffer.
+	for i := 0; i < 10; i++ {
+		q.Enqueue(i)
+	}
+	for i := 0; i < 5; i++ {
+		if v, ok := q.Dequeue(); v != i || !ok {
+			t.Fatalf("got %d, %v, want %d", v, ok, i)
+		}
+	}
+	for i := 10; i < 50; i++ {
+		q.Enqueue(i)
+	}
+	if v, _ := q.Peek(); v != 5 || q.Len() != 45 {
+		t.Fatalf("Peek = %d, Len = %d, want 5, 45", v, q.Len())
+	}
+	for i := 5; i < 50; i++ {
+		if v, ok := q.Dequeue(); v != i || !ok {
+			t.Fatalf("got %d, %v, want %d", v, ok, i)
+		}
+	}
+	if _, ok := q.Dequeue(); ok {
+		t.Fatal("dequeued from an empty queue")
+	}
+}
+
+// TestQueueConcurrent checks that each producer's items come out in the
+// order it enqueued them, and none are lost.
+func TestQueueConcurrent(t *testing.T) {
+	const producers, n = 4, 1000
+	q := NewQueue[[2]int]()
+	var wait sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wait.Add(1)
+		go func(p int) {
+			defer wait.Done()
+			for i := 0; i < n; i++ {
+				q.Enqueue([2]int{p, i})
+			}
+		}(p)
+	}
+	wait.Wait()
+	next := make([]int, producers)
+	for {
+		v, ok := q.Dequeue()
+		if !ok {
+			break
+		}
+		if v[1] != next[v[0]] {
+			t.Fatalf("producer %d: got item %d, want %d", v[0], v[1], next[v[0]])
+		}
+		next[v[0]]++
+	}
+	for p, got := range next {
+		if got != n {
+			t.Fatalf("producer %d: got %d items, want %d", p, got, n)
+		}
+	}
+}
+
+func TestStackLIFO(t *testing.T) {
+	s := NewStack[string]()
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				s.Push("x")
+			}
+		}()
+	}
+	wait.Wait()
+	if s.Len() != 4000 {
+		t.Fatalf("Len = %d, want 4000", s.Len())
+	}
+	for s.Len() > 0 {
+		s.Pop()
+	}
+	s.Push("a")
+	s.Push("b")
+	if v, _ := s.Peek(); v != "b" {
+		t.Fatalf("Peek = %q, want b", v)
+	}
+	for _, want := range []string{"b", "a"} {
+		if v, ok := s.Pop(); v != want || !ok {
+			t.Fatalf("got %q, %v, want %q", v, ok, want)
+		}
+	}
+	if _, ok := s.Pop(); ok {
+		t.Fatal("popped from an empty stack")
+	}
+}
+
+func TestQueueOnResize(t *testing.T) {
+	q := NewQueue[int]()
+	var calls [][2]int
+	q.OnResize(func(oldCap, newCap int) {
+		// Called outside the lock, so the queue can be used.
+		q.Len()
+		calls = append(calls, [2]int{oldCap, newCap})
+	})
+	for i := 0; i < minQueueCapacity+1; i++ {
+		q.Enqueue(i)
+	}
+	want := [][2]int{{minQueueCapacity, 2 * minQueueCapacity}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("growing: got %v, want %v", calls, want)
+	}
+	for q.Len() > 0 {
+		q.Dequeue()
+	}
+	want = append(want, [2]int{2 * minQueueCapacity, minQueueCapacity})
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("shrinking: got %v, want %v", calls, want)
+	}
+	q.OnResize(nil)
+	for i := 0; i < 2*minQueueCapacity; i++ {
+		q.Enqueue(i)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("callback called after removal: %v", calls)
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+// ItemRef is an opaque, stable reference to one item of a concurrent slice.
+// Unlike an index it keeps pointing at the same item when other items are
+// added or removed.
+type ItemRef struct {
+	id uint64
+}
+
+// ConcurrentSliceRefItem contains an item of a concurrent slice along with
+// a stable reference to it.
+type ConcurrentSliceRefItem struct {
+	Ref   ItemRef
+	Value interface{}
+}
+
+// push appends items to the slice, giving each a new ID. The write lock
+// must be held. If the append crosses the high-water mark, push returns a
+// function calling the high-water callback, which the caller must run once
+// the lock is released; otherwise it returns nil.
+func (cs *ConcurrentSlice) push(items ...interface{}) (notify func()) {
+	before := len(cs.items)
+	cs.items = append(cs.items, items...)
+	for range items {
+		cs.nextID++
+		cs.ids = append(cs.ids, cs.nextID)
+	}
+	cs.version++
+	n, fn := len(cs.items), cs.highWaterFn
+	if fn == nil || before >= cs.highWater || n < cs.highWater {
+		return nil
+	}
+	return func() { fn(n) }
+}
+
+// reset replaces the contents of the slice with items, giving each a new
+// ID. The write lock must be held.
+func (cs *ConcurrentSlice) reset(items []interface{}) {
+	cs.items = items
+	cs.ids = make([]uint64, len(items))
+	for i := range cs.ids {
+		cs.nextID++
+		cs.ids[i] = cs.nextID
+	}
+	cs.version++
+}
+
+// IterRef iterates over a snapshot of the items in the concurrent slice,
+// sending each along with a reference that can later be passed to
+// DeleteRef. The read lock is released once the snapshot is taken, so
+// DeleteRef can be called while ranging.
+func (cs *ConcurrentSlice) IterRef() <-chan ConcurrentSliceRefItem {
+	cs.RLock()
+	items := make([]interface{}, len(cs.items))
+	copy(items, cs.items)
+	ids := make([]uint64, len(cs.ids))
+	copy(ids, cs.ids)
+	cs.RUnlock()
+
+	c := make(chan ConcurrentSliceRefItem)
+	f := func() {
+		for i, value := range items {
+			c <- ConcurrentSliceRefItem{ItemRef{ids[i]}, value}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+// DeleteRef removes the item ref points to, wherever it has moved since the
+// reference was taken. It reports false if the item is no longer in the
+// slice.
+func (cs *ConcurrentSlice) DeleteRef(ref ItemRef) bool {
+	cs.Lock()
+	defer cs.Unlock()
+	for i, id := range cs.ids {
+		if id == ref.id {
+			n := i + copy(cs.items[i:], cs.items[i+1:])
+			cs.items[n] = nil
+			cs.items = cs.items[:n]
+			cs.ids = append(cs.ids[:i], cs.ids[i+1:]...)
+			cs.version++
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	utils "github.com/maurodelazeri/concurrency-map-slice"
+)
+
+//////////////////////////////////// THE TESTING CODE ////////////////////////////////
+
+type appender interface {
+	Append(item interface{})
+}
+
+// BenchmarkAppend appends b.N items spread over n goroutines.
+func BenchmarkAppend(n int, newSlice func() (appender, func())) string {
+	r := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		s, closeFn := newSlice()
+		var wait sync.WaitGroup
+		for g := 0; g < n; g++ {
+			wait.Add(1)
+			go func(g int) {
+				defer wait.Done()
+				for i := g; i < b.N; i += n {
+					s.Append(i)
+				}
+			}(g)
+		}
+		wait.Wait()
+		closeFn()
+	})
+	return r.String() + " " + r.MemString()
+}
+
+func main() {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	plain := func() (appender, func()) {
+		return utils.NewConcurrentSlice(), func() {}
+	}
+	buffered := func(flushEvery int) func() (appender, func()) {
+		return func() (appender, func()) {
+			bs := utils.NewBufferedSlice(flushEvery)
+			return bs, bs.Close
+		}
+	}
+	for _, n := range []int{1, 8, 64} {
+		fmt.Println("Appending from", n, "goroutines on", runtime.NumCPU(), "CPUs")
+		fmt.Println("ConcurrentSlice:      ", BenchmarkAppend(n, plain))
+		fmt.Println("BufferedSlice(64):    ", BenchmarkAppend(n, buffered(64)))
+		fmt.Println("BufferedSlice(1024):  ", BenchmarkAppend(n, buffered(1024)))
+	}
+}
@@ -1,11 +1,23 @@
 package utils
 
-import "sync"
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+)
 
 // ConcurrentSlice type that can be safely shared between goroutines.
 type ConcurrentSlice struct {
 	sync.RWMutex
-	items []interface{}
+	items   []interface{}
+	ids     []uint64
+	nextID  uint64
+	version uint64
+
+	highWater   int
+	highWaterFn func(len int)
 }
 
 // ConcurrentSliceItem contains the index/value pair of an item in a
@@ -19,6 +31,7 @@ type ConcurrentSliceItem struct {
 func NewConcurrentSlice() *ConcurrentSlice {
 	cs := &ConcurrentSlice{
 		items: make([]interface{}, 0),
+		ids:   make([]uint64, 0),
 	}
 
 	return cs
@@ -26,9 +39,58 @@ func NewConcurrentSlice() *ConcurrentSlice {
 
 // Append adds an item to the concurrent slice.
 func (cs *ConcurrentSlice) Append(item interface{}) {
+	cs.Lock()
+	notify := cs.push(item)
+	cs.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+// SetHighWaterMark registers fn to be called, outside the lock, whenever an
+// append brings the length of the concurrent slice from below threshold to
+// threshold or above, so producers can slow down or trigger a flush. This
+// includes the batched appends of a BufferedSlice. fn is called once per
+// upward crossing, not on every append past the mark, with the length right
+// after the crossing append. A nil fn removes the mark.
+func (cs *ConcurrentSlice) SetHighWaterMark(threshold int, fn func(len int)) {
 	cs.Lock()
 	defer cs.Unlock()
-	cs.items = append(cs.items, item)
+	cs.highWater = threshold
+	cs.highWaterFn = fn
+}
+
+// ReplaceAll atomically replaces the contents of the concurrent slice with a
+// copy of items, so readers see either the old or the new contents in full.
+func (cs *ConcurrentSlice) ReplaceAll(items []interface{}) {
+	replacement := make([]interface{}, len(items))
+	copy(replacement, items)
+	cs.Lock()
+	defer cs.Unlock()
+	cs.reset(replacement)
+}
+
+// Version returns the version of the concurrent slice, which is bumped by
+// every mutation.
+func (cs *ConcurrentSlice) Version() uint64 {
+	cs.RLock()
+	defer cs.RUnlock()
+	return cs.version
+}
+
+// CompareAndReplaceAll replaces the contents of the concurrent slice with a
+// copy of items, like ReplaceAll, but only if the slice is still at
+// expectedVersion. It reports whether the contents were replaced.
+func (cs *ConcurrentSlice) CompareAndReplaceAll(expectedVersion uint64, items []interface{}) bool {
+	replacement := make([]interface{}, len(items))
+	copy(replacement, items)
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.version != expectedVersion {
+		return false
+	}
+	cs.reset(replacement)
+	return true
 }
 
 // get an index
@@ -62,3 +124,533 @@ func (cs *ConcurrentSlice) Iter() <-chan ConcurrentSliceItem {
 
 	return c
 }
+
+// IterBuffered iterates over a snapshot of the items in the concurrent slice
+// using a channel buffered to bufSize items, so the producer can run ahead of
+// a bursty consumer. The read lock is released once the snapshot is taken,
+// so a consumer that stops ranging early never blocks writers.
+func (cs *ConcurrentSlice) IterBuffered(bufSize int) <-chan ConcurrentSliceItem {
+	cs.RLock()
+	items := make([]interface{}, len(cs.items))
+	copy(items, cs.items)
+	cs.RUnlock()
+
+	c := make(chan ConcurrentSliceItem, bufSize)
+	f := func() {
+		for index, value := range items {
+			c <- ConcurrentSliceItem{index, value}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+// DiffAgainst compares the current items against a previous snapshot and
+// returns the items that were added (present now but not in other) and the
+// items that were removed (present in other but not now). Items are matched
+// using eq, so uncomparable values can be diffed too.
+func (cs *ConcurrentSlice) DiffAgainst(other []interface{}, eq func(a, b interface{}) bool) (added, removed []interface{}) {
+	cs.RLock()
+	defer cs.RUnlock()
+	contains := func(arr []interface{}, item interface{}) bool {
+		for _, v := range arr {
+			if eq(v, item) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range cs.items {
+		if !contains(other, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range other {
+		if !contains(cs.items, v) {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+// Hash returns a 64-bit FNV-1a hash of the items in the concurrent slice,
+// for cheaply detecting whether the contents changed. Each item is hashed
+// through its Go-syntax fmt representation (%#v), so items that print the
+// same hash the same, and pointers hash by address rather than by the value
+// they point to.
+func (cs *ConcurrentSlice) Hash() uint64 {
+	cs.RLock()
+	defer cs.RUnlock()
+	h := fnv.New64a()
+	for _, v := range cs.items {
+		fmt.Fprintf(h, "%#v", v)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// TakeWhile returns a copy of the longest leading run of items satisfying
+// pred.
+func (cs *ConcurrentSlice) TakeWhile(pred func(interface{}) bool) []interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	n := cs.leadingRun(pred)
+	taken := make([]interface{}, n)
+	copy(taken, cs.items[:n])
+	return taken
+}
+
+// DropWhile returns a copy of the items remaining after the longest leading
+// run of items satisfying pred.
+func (cs *ConcurrentSlice) DropWhile(pred func(interface{}) bool) []interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	n := cs.leadingRun(pred)
+	rest := make([]interface{}, len(cs.items)-n)
+	copy(rest, cs.items[n:])
+	return rest
+}
+
+func (cs *ConcurrentSlice) leadingRun(pred func(interface{}) bool) int {
+	for i, v := range cs.items {
+		if !pred(v) {
+			return i
+		}
+	}
+	return len(cs.items)
+}
+
+// Stats returns the length and capacity of the concurrent slice and the
+// number of nil items it holds, all read under a single lock acquisition.
+func (cs *ConcurrentSlice) Stats() (length, capacity, nilCount int) {
+	cs.RLock()
+	defer cs.RUnlock()
+	for _, v := range cs.items {
+		if v == nil {
+			nilCount++
+		}
+	}
+	return len(cs.items), cap(cs.items), nilCount
+}
+
+// Flatten returns a new concurrent slice in which items that are themselves
+// a *ConcurrentSlice or a []interface{} are replaced, in order, by their
+// own items. Other items are kept as is. Only one level of nesting is
+// flattened.
+func (cs *ConcurrentSlice) Flatten() *ConcurrentSlice {
+	cs.RLock()
+	items := make([]interface{}, len(cs.items))
+	copy(items, cs.items)
+	cs.RUnlock()
+
+	flat := NewConcurrentSlice()
+	for _, v := range items {
+		switch v := v.(type) {
+		case *ConcurrentSlice:
+			v.RLock()
+			flat.push(v.items...)
+			v.RUnlock()
+		case []interface{}:
+			flat.push(v...)
+		default:
+			flat.push(v)
+		}
+	}
+
+	return flat
+}
+
+type actionOp int
+
+const (
+	opKeep actionOp = iota
+	opDelete
+	opReplace
+)
+
+// Action tells ForEachMutable what to do with an item. The zero Action
+// keeps the item.
+type Action struct {
+	op    actionOp
+	value interface{}
+}
+
+// ActionKeep returns an action leaving the item as it is.
+func ActionKeep() Action {
+	return Action{op: opKeep}
+}
+
+// ActionDelete returns an action removing the item.
+func ActionDelete() Action {
+	return Action{op: opDelete}
+}
+
+// ActionReplace returns an action replacing the item with value.
+func ActionReplace(value interface{}) Action {
+	return Action{op: opReplace, value: value}
+}
+
+// ForEachMutable calls fn for each item of the concurrent slice and applies
+// the returned action, rebuilding the slice in a single pass under the write
+// lock. Indices passed to fn are those of the slice before the call. fn must
+// not call back into the slice.
+func (cs *ConcurrentSlice) ForEachMutable(fn func(index int, value interface{}) Action) {
+	cs.Lock()
+	defer cs.Unlock()
+	items := make([]interface{}, 0, len(cs.items))
+	ids := make([]uint64, 0, len(cs.ids))
+	for index, value := range cs.items {
+		switch a := fn(index, value); a.op {
+		case opKeep:
+			items = append(items, value)
+			ids = append(ids, cs.ids[index])
+		case opReplace:
+			items = append(items, a.value)
+			ids = append(ids, cs.ids[index])
+		}
+	}
+	cs.items = items
+	cs.ids = ids
+	cs.version++
+}
+
+// FilterMapInPlace calls fn for each item of the concurrent slice and, in a
+// single pass under the write lock, replaces the item with the returned
+// value if fn reports true or removes it otherwise. The order of the kept
+// items is preserved. fn must not call back into the slice.
+func (cs *ConcurrentSlice) FilterMapInPlace(fn func(interface{}) (interface{}, bool)) {
+	cs.Lock()
+	defer cs.Unlock()
+	n := 0
+	for i, v := range cs.items {
+		if v, ok := fn(v); ok {
+			cs.items[n] = v
+			cs.ids[n] = cs.ids[i]
+			n++
+		}
+	}
+	for i := n; i < len(cs.items); i++ {
+		cs.items[i] = nil
+	}
+	cs.items = cs.items[:n]
+	cs.ids = cs.ids[:n]
+	cs.version++
+}
+
+// ToMap returns a map of the items of the concurrent slice keyed by keyFn.
+// When several items map to the same key, the later item wins.
+func (cs *ConcurrentSlice) ToMap(keyFn func(interface{}) string) map[string]interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	m := make(map[string]interface{}, len(cs.items))
+	for _, v := range cs.items {
+		m[keyFn(v)] = v
+	}
+	return m
+}
+
+// Tail returns a copy of the last n items of the concurrent slice, in order.
+// It returns fewer items if the slice is shorter, and none if n <= 0.
+func (cs *ConcurrentSlice) Tail(n int) []interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	if n < 0 {
+		n = 0
+	}
+	if n > len(cs.items) {
+		n = len(cs.items)
+	}
+	tail := make([]interface{}, n)
+	copy(tail, cs.items[len(cs.items)-n:])
+	return tail
+}
+
+// WindowReduce slides a window of size items over a snapshot of the
+// concurrent slice and returns reduce applied to each window, e.g. for a
+// moving average. The window passed to reduce shares memory with the next
+// ones, so reduce must neither modify nor keep it. No values are returned
+// if size is not positive or exceeds the length of the slice.
+func (cs *ConcurrentSlice) WindowReduce(size int, reduce func(window []interface{}) interface{}) []interface{} {
+	cs.RLock()
+	items := make([]interface{}, len(cs.items))
+	copy(items, cs.items)
+	cs.RUnlock()
+
+	if size <= 0 || size > len(items) {
+		return nil
+	}
+	reduced := make([]interface{}, 0, len(items)-size+1)
+	for i := 0; i+size <= len(items); i++ {
+		reduced = append(reduced, reduce(items[i:i+size:i+size]))
+	}
+	return reduced
+}
+
+// Sample returns k items chosen uniformly at random, without replacement,
+// from the concurrent slice using reservoir sampling over the items read
+// under the read lock. k is clamped to the length of the slice. rnd is the
+// source of randomness, so a seeded source gives a deterministic sample.
+func (cs *ConcurrentSlice) Sample(k int, rnd *rand.Rand) []interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	if k > len(cs.items) {
+		k = len(cs.items)
+	}
+	if k <= 0 {
+		return []interface{}{}
+	}
+	sample := make([]interface{}, k)
+	copy(sample, cs.items[:k])
+	for i := k; i < len(cs.items); i++ {
+		if j := rnd.Intn(i + 1); j < k {
+			sample[j] = cs.items[i]
+		}
+	}
+	return sample
+}
+
+// Permute reorders the items of the concurrent slice in one step so that the
+// item at index i becomes the one previously at index perm[i]. It reports
+// false, leaving the slice untouched, if perm is not a permutation of
+// [0, len).
+func (cs *ConcurrentSlice) Permute(perm []int) bool {
+	cs.Lock()
+	defer cs.Unlock()
+	if len(perm) != len(cs.items) {
+		return false
+	}
+	seen := make([]bool, len(perm))
+	for _, p := range perm {
+		if p < 0 || p >= len(perm) || seen[p] {
+			return false
+		}
+		seen[p] = true
+	}
+	items := make([]interface{}, len(perm))
+	ids := make([]uint64, len(perm))
+	for i, p := range perm {
+		items[i] = cs.items[p]
+		ids[i] = cs.ids[p]
+	}
+	cs.items = items
+	cs.ids = ids
+	cs.version++
+	return true
+}
+
+// Join concatenates the items of the concurrent slice, separated by sep,
+// like strings.Join. String items are used as is and any other item is
+// formatted with fmt.Sprint.
+func (cs *ConcurrentSlice) Join(sep string) string {
+	cs.RLock()
+	defer cs.RUnlock()
+	var b strings.Builder
+	for i, v := range cs.items {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		if s, ok := v.(string); ok {
+			b.WriteString(s)
+		} else {
+			fmt.Fprint(&b, v)
+		}
+	}
+	return b.String()
+}
+
+// DedupInPlace removes duplicate items from the concurrent slice, keeping the
+// first occurrence of each, and returns the number of items removed. Items
+// of uncomparable types (slices, maps, funcs) can't be matched and are
+// always kept.
+func (cs *ConcurrentSlice) DedupInPlace() int {
+	cs.Lock()
+	defer cs.Unlock()
+	seen := make(map[interface{}]struct{}, len(cs.items))
+	n := 0
+	for i, v := range cs.items {
+		if firstSeen(seen, v) {
+			cs.items[n] = v
+			cs.ids[n] = cs.ids[i]
+			n++
+		}
+	}
+	removed := len(cs.items) - n
+	if removed > 0 {
+		for i := n; i < len(cs.items); i++ {
+			cs.items[i] = nil
+		}
+		cs.items = cs.items[:n]
+		cs.ids = cs.ids[:n]
+		cs.version++
+	}
+	return removed
+}
+
+// firstSeen records v in seen and reports whether it wasn't there yet.
+// Uncomparable values can't be recorded and always count as first seen.
+func firstSeen(seen map[interface{}]struct{}, v interface{}) (first bool) {
+	defer func() {
+		if recover() != nil {
+			first = true
+		}
+	}()
+	if _, ok := seen[v]; ok {
+		return false
+	}
+	seen[v] = struct{}{}
+	return true
+}
+
+// FindIndex returns the index of the first item satisfying pred, or -1.
+func (cs *ConcurrentSlice) FindIndex(pred func(interface{}) bool) int {
+	cs.RLock()
+	defer cs.RUnlock()
+	for i, v := range cs.items {
+		if pred(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindLastIndex returns the index of the last item satisfying pred, or -1.
+func (cs *ConcurrentSlice) FindLastIndex(pred func(interface{}) bool) int {
+	cs.RLock()
+	defer cs.RUnlock()
+	for i := len(cs.items) - 1; i >= 0; i-- {
+		if pred(cs.items[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReduceParallel folds a snapshot of the concurrent slice with combine,
+// splitting it into chunks reduced concurrently by up to workers goroutines
+// and then combining the partial results. combine must be associative and
+// identity must be its identity element, as the grouping of the items
+// differs from a sequential fold.
+func (cs *ConcurrentSlice) ReduceParallel(workers int, identity interface{}, combine func(a, b interface{}) interface{}) interface{} {
+	cs.RLock()
+	items := make([]interface{}, len(cs.items))
+	copy(items, cs.items)
+	cs.RUnlock()
+
+	if workers > len(items) {
+		workers = len(items)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	chunk := (len(items) + workers - 1) / workers
+	partials := make([]interface{}, workers)
+	var wait sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wait.Add(1)
+		go func(w int) {
+			defer wait.Done()
+			acc := identity
+			for i := w * chunk; i < len(items) && i < (w+1)*chunk; i++ {
+				acc = combine(acc, items[i])
+			}
+			partials[w] = acc
+		}(w)
+	}
+	wait.Wait()
+
+	result := identity
+	for _, p := range partials {
+		result = combine(result, p)
+	}
+	return result
+}
+
+// IterCopy is like IterBuffered with no buffer, but each item is deep
+// copied with DeepCopy before being sent, so consumers can't mutate what
+// the slice holds.
+func (cs *ConcurrentSlice) IterCopy() <-chan ConcurrentSliceItem {
+	cs.RLock()
+	items := make([]interface{}, len(cs.items))
+	for i, v := range cs.items {
+		items[i] = DeepCopy(v)
+	}
+	cs.RUnlock()
+
+	c := make(chan ConcurrentSliceItem)
+	f := func() {
+		for index, value := range items {
+			c <- ConcurrentSliceItem{index, value}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+// PopFront removes and returns the first item of the concurrent slice. It
+// shifts the remaining items down, so it is O(n); use Queue for heavy FIFO
+// workloads.
+func (cs *ConcurrentSlice) PopFront() (interface{}, bool) {
+	cs.Lock()
+	defer cs.Unlock()
+	if len(cs.items) == 0 {
+		return nil, false
+	}
+	item := cs.items[0]
+	n := copy(cs.items, cs.items[1:])
+	cs.items[n] = nil
+	cs.items = cs.items[:n]
+	copy(cs.ids, cs.ids[1:])
+	cs.ids = cs.ids[:n]
+	cs.version++
+	return item, true
+}
+
+// TakeRange removes the items in [start, end) from the concurrent slice and
+// returns a copy of them, shifting the following items down in the same
+// write-locked step. It reports false, leaving the slice untouched, if the
+// range is not within the slice.
+func (cs *ConcurrentSlice) TakeRange(start, end int) ([]interface{}, bool) {
+	cs.Lock()
+	defer cs.Unlock()
+	if start < 0 || end < start || end > len(cs.items) {
+		return nil, false
+	}
+	taken := make([]interface{}, end-start)
+	copy(taken, cs.items[start:end])
+	if len(taken) == 0 {
+		return taken, true
+	}
+	n := start + copy(cs.items[start:], cs.items[end:])
+	for i := n; i < len(cs.items); i++ {
+		cs.items[i] = nil
+	}
+	cs.items = cs.items[:n]
+	copy(cs.ids[start:], cs.ids[end:])
+	cs.ids = cs.ids[:n]
+	cs.version++
+	return taken, true
+}
+
+// MinMax returns the smallest and largest items of the concurrent slice
+// according to less, found in a single scan under the read lock. Among
+// equal items the first one is returned. ok is false if the slice is empty.
+func (cs *ConcurrentSlice) MinMax(less func(a, b interface{}) bool) (min, max interface{}, ok bool) {
+	cs.RLock()
+	defer cs.RUnlock()
+	if len(cs.items) == 0 {
+		return nil, nil, false
+	}
+	min, max = cs.items[0], cs.items[0]
+	for _, v := range cs.items[1:] {
+		if less(v, min) {
+			min = v
+		} else if less(max, v) {
+			max = v
+		}
+	}
+	return min, max, true
+}
@@ -0,0 +1,776 @@
+package utils
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestIterCopy(t *testing.T) {
+	cs := NewConcurrentSlice()
+	n := 1
+	cs.Append([]int{1, 2})
+	cs.Append(map[string]int{"a": 1})
+	cs.Append(&n)
+	for item := range cs.IterCopy() {
+		switch v := item.Value.(type) {
+		case []int:
+			v[0] = 100
+		case map[string]int:
+			v["a"] = 100
+		case *int:
+			*v = 100
+		}
+	}
+	if v := cs.Get(0).([]int); v[0] != 1 {
+		t.Fatalf("slice changed to %v", v)
+	}
+	if v := cs.Get(1).(map[string]int); v["a"] != 1 {
+		t.Fatalf("map changed to %v", v)
+	}
+	if n != 1 {
+		t.Fatalf("pointed-to value changed to %d", n)
+	}
+}
+
+func TestDeepCopyCycles(t *testing.T) {
+	m := map[string]interface{}{}
+	m["self"] = m
+	shared := []int{1}
+	m["a"], m["b"] = shared, shared
+	s := NewConcurrentSlice()
+	m["struct"] = s
+
+	c := DeepCopy(m).(map[string]interface{})
+	if reflect.ValueOf(c["self"]).Pointer() != reflect.ValueOf(c).Pointer() {
+		t.Fatal("cycle not kept in the copy")
+	}
+	c["a"].([]int)[0] = 100
+	if shared[0] != 1 || c["b"].([]int)[0] != 100 {
+		t.Fatalf("shared slice not copied once: original %v, copy %v", shared, c["b"])
+	}
+	if c["struct"] != s {
+		t.Fatal("pointer to struct was copied")
+	}
+}
+
+// BenchmarkIter compares the goroutine and channel cost of Iter against a
+// buffered channel, a read-only callback under one read lock (FindIndex with
+// a predicate that never matches) and an indexed Get loop.
+func BenchmarkIter(b *testing.B) {
+	for _, size := range []int{10, 1000, 100000} {
+		cs := NewConcurrentSlice()
+		for i := 0; i < size; i++ {
+			cs.Append(i)
+		}
+		styles := []struct {
+			name    string
+			iterate func() int
+		}{
+			{"Iter", func() int {
+				n := 0
+				for range cs.Iter() {
+					n++
+				}
+				return n
+			}},
+			{"IterBuffered64", func() int {
+				n := 0
+				for range cs.IterBuffered(64) {
+					n++
+				}
+				return n
+			}},
+			{"FindIndex", func() int {
+				n := 0
+				cs.FindIndex(func(interface{}) bool {
+					n++
+					return false
+				})
+				return n
+			}},
+			{"Get", func() int {
+				n := 0
+				for i := 0; i < size; i++ {
+					if cs.Get(i) != nil {
+						n++
+					}
+				}
+				return n
+			}},
+		}
+		for _, style := range styles {
+			b.Run(style.name+"/"+strconv.Itoa(size), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if n := style.iterate(); n != size {
+						b.Fatalf("got %d items, want %d", n, size)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestView(t *testing.T) {
+	cs := NewConcurrentSlice()
+	for i := 0; i < 5; i++ {
+		cs.Append(i)
+	}
+	v := cs.View(1, 4)
+	if v.Len() != 3 || v.Get(0) != 1 {
+		t.Fatalf("got len %d, first %v", v.Len(), v.Get(0))
+	}
+	cs.ForEachMutable(func(index int, value interface{}) Action {
+		if index == 2 {
+			return ActionReplace(20)
+		}
+		return ActionKeep()
+	})
+	if got := v.Get(1); got != 20 {
+		t.Fatalf("parent change not visible: got %v", got)
+	}
+	if v.Get(3) != nil || v.Get(-1) != nil {
+		t.Fatal("Get outside the view returned an item")
+	}
+	cs.ReplaceAll([]interface{}{0, 1, 2})
+	if v.Len() != 2 || v.Get(2) != nil {
+		t.Fatalf("view not clamped: len %d", v.Len())
+	}
+}
+
+func TestIterBufferedEarlyExit(t *testing.T) {
+	cs := NewConcurrentSlice()
+	for i := 0; i < 10; i++ {
+		cs.Append(i)
+	}
+	c := cs.IterBuffered(2)
+	if item := <-c; item.Index != 0 || item.Value != 0 {
+		t.Fatalf("got %v, want {0 0}", item)
+	}
+	// The consumer stopped early; writers must not block on it.
+	cs.Append(10)
+	n := 1
+	for range c {
+		n++
+	}
+	if n != 10 {
+		t.Fatalf("got %d items, want the 10 of the snapshot", n)
+	}
+}
+
+func BenchmarkIterBuffered(b *testing.B) {
+	cs := NewConcurrentSlice()
+	for i := 0; i < 100000; i++ {
+		cs.Append(i)
+	}
+	for _, bufSize := range []int{0, 64, 1024} {
+		b.Run(strconv.Itoa(bufSize), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for range cs.IterBuffered(bufSize) {
+				}
+			}
+		})
+	}
+}
+
+func newSlice(items ...interface{}) *ConcurrentSlice {
+	cs := NewConcurrentSlice()
+	for _, v := range items {
+		cs.Append(v)
+	}
+	return cs
+}
+
+func TestDiffAgainst(t *testing.T) {
+	eq := func(a, b interface{}) bool { return reflect.DeepEqual(a, b) }
+	tests := []struct {
+		now, before    []interface{}
+		added, removed []interface{}
+	}{
+		{[]interface{}{1, 2, 3}, []interface{}{2, 3, 4}, []interface{}{1}, []interface{}{4}},
+		{[]interface{}{1, 2}, []interface{}{3, 4}, []interface{}{1, 2}, []interface{}{3, 4}},
+		{[]interface{}{1, 2}, []interface{}{1, 2}, nil, nil},
+		// Slices aren't comparable with ==, eq must be used.
+		{[]interface{}{[]int{1}}, []interface{}{[]int{1}, []int{2}}, nil, []interface{}{[]int{2}}},
+	}
+	for _, tt := range tests {
+		added, removed := newSlice(tt.now...).DiffAgainst(tt.before, eq)
+		if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("%v against %v: got %v, %v, want %v, %v", tt.now, tt.before, added, removed, tt.added, tt.removed)
+		}
+	}
+}
+
+// TestReplaceAllAtomic swaps between two contents of different lengths
+// while a reader checks it never sees a mix of them.
+func TestReplaceAllAtomic(t *testing.T) {
+	old := []interface{}{"old", "old", "old"}
+	fresh := []interface{}{"new", "new", "new", "new", "new"}
+	cs := newSlice(old...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				cs.ReplaceAll(fresh)
+			} else {
+				cs.ReplaceAll(old)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		var got []interface{}
+		for item := range cs.IterBuffered(8) {
+			got = append(got, item.Value)
+		}
+		if !reflect.DeepEqual(got, old) && !reflect.DeepEqual(got, fresh) {
+			t.Fatalf("saw a mix: %v", got)
+		}
+	}
+}
+
+func TestReplaceAllCopies(t *testing.T) {
+	items := []interface{}{1, 2}
+	cs := NewConcurrentSlice()
+	cs.ReplaceAll(items)
+	items[0] = 100
+	if cs.Get(0) != 1 {
+		t.Fatalf("ReplaceAll kept the caller's slice: got %v", cs.Get(0))
+	}
+}
+
+func TestHash(t *testing.T) {
+	a, b := newSlice(1, "x", []int{2}), newSlice(1, "x", []int{2})
+	if a.Hash() != b.Hash() {
+		t.Fatal("equal contents hash differently")
+	}
+	b.ReplaceAll([]interface{}{1, "y", []int{2}})
+	if a.Hash() == b.Hash() {
+		t.Fatal("changing an item kept the hash")
+	}
+	// Item boundaries are part of the hash.
+	if newSlice("ab", "c").Hash() == newSlice("a", "bc").Hash() {
+		t.Fatal("different splits hash the same")
+	}
+}
+
+// contents returns a copy of the items of cs.
+func contents(cs *ConcurrentSlice) []interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	return append([]interface{}{}, cs.items...)
+}
+
+func TestMergeSlices(t *testing.T) {
+	a, b := newSlice(1, 2), newSlice(3)
+	merged := MergeSlices(a, b, a)
+	want := []interface{}{1, 2, 3, 1, 2}
+	if got := contents(merged); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	merged.Append(4)
+	if len(contents(a)) != 2 {
+		t.Fatal("appending to the merged slice changed a source")
+	}
+}
+
+func TestTakeWhileDropWhile(t *testing.T) {
+	cs := newSlice(1, 2, 3, 4, 5)
+	below := func(n int) func(interface{}) bool {
+		return func(v interface{}) bool { return v.(int) < n }
+	}
+	tests := []struct {
+		threshold   int
+		taken, rest []interface{}
+	}{
+		{3, []interface{}{1, 2}, []interface{}{3, 4, 5}},
+		{0, []interface{}{}, []interface{}{1, 2, 3, 4, 5}},
+		{10, []interface{}{1, 2, 3, 4, 5}, []interface{}{}},
+	}
+	for _, tt := range tests {
+		if got := cs.TakeWhile(below(tt.threshold)); !reflect.DeepEqual(got, tt.taken) {
+			t.Errorf("TakeWhile(< %d) = %v, want %v", tt.threshold, got, tt.taken)
+		}
+		if got := cs.DropWhile(below(tt.threshold)); !reflect.DeepEqual(got, tt.rest) {
+			t.Errorf("DropWhile(< %d) = %v, want %v", tt.threshold, got, tt.rest)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	cs := NewConcurrentSlice()
+	cs.ReplaceAll(make([]interface{}, 0, 10))
+	cs.Append(1)
+	cs.Append(nil)
+	cs.Append(nil)
+	length, capacity, nilCount := cs.Stats()
+	if length != 3 || capacity < 3 || nilCount != 2 {
+		t.Fatalf("got %d, %d, %d, want 3, >= 3, 2", length, capacity, nilCount)
+	}
+	cs.RLock()
+	want := cap(cs.items)
+	cs.RUnlock()
+	if capacity != want {
+		t.Fatalf("capacity %d, want %d", capacity, want)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	cs := newSlice(1, newSlice(2, 3), []interface{}{4, []interface{}{5}}, "6", newSlice())
+	want := []interface{}{1, 2, 3, 4, []interface{}{5}, "6"}
+	if got := contents(cs.Flatten()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestForEachMutable(t *testing.T) {
+	cs := newSlice(0, 1, 2, 3, 4, 5)
+	var seen []int
+	cs.ForEachMutable(func(index int, value interface{}) Action {
+		seen = append(seen, index)
+		switch {
+		case index%2 == 1:
+			return ActionDelete()
+		case index == 4:
+			return ActionReplace(40)
+		case index == 2:
+			return Action{}
+		}
+		return ActionKeep()
+	})
+	if want := []int{0, 1, 2, 3, 4, 5}; !reflect.DeepEqual(seen, want) {
+		t.Fatalf("fn saw indices %v, want %v", seen, want)
+	}
+	if got, want := contents(cs), []interface{}{0, 2, 40}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	type user struct {
+		id   string
+		name string
+	}
+	cs := newSlice(user{"1", "ann"}, user{"2", "bob"}, user{"1", "amy"})
+	m := cs.ToMap(func(v interface{}) string { return v.(user).id })
+	want := map[string]interface{}{"1": user{"1", "amy"}, "2": user{"2", "bob"}}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %v, want %v", m, want)
+	}
+}
+
+func TestTail(t *testing.T) {
+	cs := newSlice(1, 2, 3)
+	tests := []struct {
+		n    int
+		want []interface{}
+	}{
+		{5, []interface{}{1, 2, 3}},
+		{3, []interface{}{1, 2, 3}},
+		{2, []interface{}{2, 3}},
+		{0, []interface{}{}},
+		{-1, []interface{}{}},
+	}
+	for _, tt := range tests {
+		if got := cs.Tail(tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tail(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCompareAndReplaceAll(t *testing.T) {
+	cs := newSlice(1)
+	v := cs.Version()
+	cs.Append(2)
+	if cs.Version() == v {
+		t.Fatal("Append didn't bump the version")
+	}
+	if cs.CompareAndReplaceAll(v, []interface{}{3}) {
+		t.Fatal("replaced with a stale version")
+	}
+	if got := contents(cs); !reflect.DeepEqual(got, []interface{}{1, 2}) {
+		t.Fatalf("failed CAS changed the contents to %v", got)
+	}
+	v = cs.Version()
+	if !cs.CompareAndReplaceAll(v, []interface{}{3}) {
+		t.Fatal("CAS with the current version failed")
+	}
+	if got := contents(cs); !reflect.DeepEqual(got, []interface{}{3}) {
+		t.Fatalf("got %v, want [3]", got)
+	}
+	if cs.Version() == v {
+		t.Fatal("CompareAndReplaceAll didn't bump the version")
+	}
+}
+
+func TestHighWaterMark(t *testing.T) {
+	cs := NewConcurrentSlice()
+	var calls []int
+	cs.SetHighWaterMark(3, func(n int) {
+		// Called outside the lock, so the slice can be used.
+		cs.Tail(1)
+		calls = append(calls, n)
+	})
+	for i := 0; i < 5; i++ {
+		cs.Append(i)
+	}
+	if !reflect.DeepEqual(calls, []int{3}) {
+		t.Fatalf("got calls %v, want [3]", calls)
+	}
+	cs.PopFront()
+	cs.PopFront()
+	cs.PopFront()
+	cs.Append(5)
+	if !reflect.DeepEqual(calls, []int{3, 3}) {
+		t.Fatalf("got calls %v after crossing again, want [3 3]", calls)
+	}
+}
+
+func TestHighWaterMarkBatched(t *testing.T) {
+	bs := NewBufferedSlice(64)
+	calls := make(chan int, 10)
+	bs.SetHighWaterMark(10, func(n int) { calls <- n })
+	for i := 0; i < 100; i++ {
+		bs.Append(i)
+	}
+	bs.Close()
+	if len(calls) != 1 {
+		t.Fatalf("BufferedSlice: got %d calls, want 1", len(calls))
+	}
+
+	a := NewAccumulator(0, 0, func([]interface{}) {})
+	var accCalls []int
+	a.items.SetHighWaterMark(2, func(n int) { accCalls = append(accCalls, n) })
+	a.Add(1)
+	a.Add(2)
+	a.Add(3)
+	a.Close()
+	if !reflect.DeepEqual(accCalls, []int{2}) {
+		t.Fatalf("Accumulator: got calls %v, want [2]", accCalls)
+	}
+}
+
+func TestWindowReduce(t *testing.T) {
+	cs := newSlice(1, 2, 3, 4, 5)
+	sum := func(window []interface{}) interface{} {
+		s := 0
+		for _, v := range window {
+			s += v.(int)
+		}
+		return s
+	}
+	if got, want := cs.WindowReduce(3, sum), []interface{}{6, 9, 12}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := cs.WindowReduce(6, sum); got != nil {
+		t.Fatalf("window larger than the slice gave %v", got)
+	}
+	if got := cs.WindowReduce(0, sum); got != nil {
+		t.Fatalf("empty window gave %v", got)
+	}
+}
+
+func TestDeleteRef(t *testing.T) {
+	cs := newSlice("a", "b", "c", "d")
+	var refs []ItemRef
+	for item := range cs.IterRef() {
+		refs = append(refs, item.Ref)
+	}
+	if !cs.DeleteRef(refs[0]) || !cs.DeleteRef(refs[2]) {
+		t.Fatal("DeleteRef of a present item failed")
+	}
+	if got, want := contents(cs), []interface{}{"b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if cs.DeleteRef(refs[0]) {
+		t.Fatal("deleted the same ref twice")
+	}
+	// Refs stay tied to their item, not to a position, across a PopFront
+	// and a ReplaceAll.
+	cs.PopFront()
+	if !cs.DeleteRef(refs[3]) || len(contents(cs)) != 0 {
+		t.Fatalf("DeleteRef after PopFront left %v", contents(cs))
+	}
+	cs.ReplaceAll([]interface{}{"e"})
+	if cs.DeleteRef(refs[1]) {
+		t.Fatal("a ref deleted an item added later")
+	}
+	// The vacated tail slot must not keep the value alive.
+	cs = newSlice("x", "y")
+	c := cs.IterRef()
+	cs.DeleteRef((<-c).Ref)
+	for range c {
+	}
+	cs.RLock()
+	tail := cs.items[:cap(cs.items)][1]
+	cs.RUnlock()
+	if tail != nil {
+		t.Fatalf("tail slot still holds %v", tail)
+	}
+}
+
+func TestSample(t *testing.T) {
+	cs := NewConcurrentSlice()
+	for i := 0; i < 100; i++ {
+		cs.Append(i)
+	}
+	a := cs.Sample(10, rand.New(rand.NewSource(1)))
+	b := cs.Sample(10, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same seed gave %v and %v", a, b)
+	}
+	seen := make(map[interface{}]bool)
+	for _, v := range a {
+		if n := v.(int); n < 0 || n >= 100 || seen[v] {
+			t.Fatalf("bad sample %v", a)
+		}
+		seen[v] = true
+	}
+	if n := len(cs.Sample(1000, rand.New(rand.NewSource(1)))); n != 100 {
+		t.Fatalf("k above the length gave %d items", n)
+	}
+	if n := len(cs.Sample(0, rand.New(rand.NewSource(1)))); n != 0 {
+		t.Fatalf("k of zero gave %d items", n)
+	}
+}
+
+func TestPermute(t *testing.T) {
+	cs := newSlice("a", "b", "c")
+	var refs []ItemRef
+	for item := range cs.IterRef() {
+		refs = append(refs, item.Ref)
+	}
+	for _, bad := range [][]int{{0, 1}, {0, 1, 1}, {0, 1, 3}, {-1, 0, 1}} {
+		if cs.Permute(bad) {
+			t.Fatalf("applied invalid permutation %v", bad)
+		}
+	}
+	if !cs.Permute([]int{2, 0, 1}) {
+		t.Fatal("rejected a valid permutation")
+	}
+	if got, want := contents(cs), []interface{}{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Refs move with their items.
+	cs.DeleteRef(refs[0])
+	if got, want := contents(cs), []interface{}{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after DeleteRef got %v, want %v", got, want)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	if got := newSlice("a", "b", "c").Join(", "); got != "a, b, c" {
+		t.Fatalf("got %q", got)
+	}
+	if got := newSlice("a", 1, 2.5, nil, []int{3}).Join("-"); got != "a-1-2.5-<nil>-[3]" {
+		t.Fatalf("got %q", got)
+	}
+	if got := NewConcurrentSlice().Join(","); got != "" {
+		t.Fatalf("empty slice gave %q", got)
+	}
+}
+
+func TestDedupInPlace(t *testing.T) {
+	cs := newSlice(1, "a", 1, 2, "a", []int{1}, []int{1}, 2, nil, nil)
+	if n := cs.DedupInPlace(); n != 4 {
+		t.Fatalf("removed %d, want 4", n)
+	}
+	want := []interface{}{1, "a", 2, []int{1}, []int{1}, nil}
+	if got := contents(cs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if n := cs.DedupInPlace(); n != 0 {
+		t.Fatalf("second pass removed %d", n)
+	}
+}
+
+func TestFindIndex(t *testing.T) {
+	type user struct {
+		name string
+		age  int
+	}
+	cs := newSlice(user{"ann", 30}, user{"bob", 40}, user{"cid", 30})
+	age := func(n int) func(interface{}) bool {
+		return func(v interface{}) bool { return v.(user).age == n }
+	}
+	if i := cs.FindIndex(age(30)); i != 0 {
+		t.Errorf("FindIndex = %d, want 0", i)
+	}
+	if i := cs.FindLastIndex(age(30)); i != 2 {
+		t.Errorf("FindLastIndex = %d, want 2", i)
+	}
+	if i, j := cs.FindIndex(age(50)), cs.FindLastIndex(age(50)); i != -1 || j != -1 {
+		t.Errorf("no match gave %d, %d, want -1, -1", i, j)
+	}
+	calls := 0
+	cs.FindIndex(func(v interface{}) bool {
+		calls++
+		return true
+	})
+	if calls != 1 {
+		t.Errorf("pred called %d times after a match, want 1", calls)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	less := func(a, b interface{}) bool { return a.(int) < b.(int) }
+	for trial := 0; trial < 20; trial++ {
+		items := make([]interface{}, rnd.Intn(50))
+		for i := range items {
+			items[i] = rnd.Intn(20)
+		}
+		cs := newSlice(items...)
+		sorted := append([]interface{}{}, items...)
+		sort.Slice(sorted, func(i, j int) bool { return less(sorted[j], sorted[i]) })
+		for _, k := range []int{0, 1, 5, len(items), len(items) + 3} {
+			want := sorted
+			if k < len(sorted) {
+				want = sorted[:k]
+			}
+			if got := cs.TopK(k, less); !reflect.DeepEqual(got, want) {
+				t.Fatalf("TopK(%d) of %v = %v, want %v", k, items, got, want)
+			}
+		}
+	}
+}
+
+func TestReduceParallel(t *testing.T) {
+	cs := NewConcurrentSlice()
+	want := 0
+	for i := 0; i < 100000; i++ {
+		cs.Append(i)
+		want += i
+	}
+	sum := func(a, b interface{}) interface{} { return a.(int) + b.(int) }
+	for _, workers := range []int{-1, 0, 1, 3, 8, 200000} {
+		if got := cs.ReduceParallel(workers, 0, sum); got != want {
+			t.Errorf("%d workers: got %v, want %d", workers, got, want)
+		}
+	}
+	if got := NewConcurrentSlice().ReduceParallel(4, 0, sum); got != 0 {
+		t.Errorf("empty slice gave %v, want the identity", got)
+	}
+}
+
+func TestPopFrontConcurrent(t *testing.T) {
+	cs := NewConcurrentSlice()
+	const producers, n = 4, 1000
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	popped := make(map[interface{}]bool)
+	for p := 0; p < producers; p++ {
+		wait.Add(2)
+		go func(p int) {
+			defer wait.Done()
+			for i := 0; i < n; i++ {
+				cs.Append(p*n + i)
+			}
+		}(p)
+		go func() {
+			defer wait.Done()
+			for got := 0; got < n; {
+				if v, ok := cs.PopFront(); ok {
+					lock.Lock()
+					if popped[v] {
+						t.Errorf("%v popped twice", v)
+					}
+					popped[v] = true
+					lock.Unlock()
+					got++
+				}
+			}
+		}()
+	}
+	wait.Wait()
+	if len(popped) != producers*n {
+		t.Fatalf("popped %d distinct items, want %d", len(popped), producers*n)
+	}
+	if _, ok := cs.PopFront(); ok {
+		t.Fatal("popped from an empty slice")
+	}
+}
+
+func TestPopFrontOrder(t *testing.T) {
+	cs := newSlice(1, 2, 3)
+	for _, want := range []int{1, 2, 3} {
+		if v, ok := cs.PopFront(); v != want || !ok {
+			t.Fatalf("got %v, %v, want %d", v, ok, want)
+		}
+	}
+}
+
+func TestFilterMapInPlace(t *testing.T) {
+	cs := newSlice(1, 2, 3, 4, 5, 6)
+	calls := 0
+	cs.FilterMapInPlace(func(v interface{}) (interface{}, bool) {
+		calls++
+		n := v.(int)
+		return n * 10, n%2 == 0
+	})
+	if calls != 6 {
+		t.Fatalf("fn called %d times, want 6", calls)
+	}
+	if got, want := contents(cs), []interface{}{20, 40, 60}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestTakeRange(t *testing.T) {
+	cs := newSlice(0, 1, 2, 3, 4, 5)
+	taken, ok := cs.TakeRange(2, 4)
+	if !ok || !reflect.DeepEqual(taken, []interface{}{2, 3}) {
+		t.Fatalf("got %v, %v, want [2 3], true", taken, ok)
+	}
+	if got, want := contents(cs), []interface{}{0, 1, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("survivors %v, want %v", got, want)
+	}
+	for _, r := range [][2]int{{-1, 1}, {3, 2}, {0, 5}} {
+		if _, ok := cs.TakeRange(r[0], r[1]); ok {
+			t.Fatalf("took invalid range %v", r)
+		}
+	}
+	if taken, ok := cs.TakeRange(1, 1); !ok || len(taken) != 0 {
+		t.Fatalf("empty range got %v, %v", taken, ok)
+	}
+	if taken, ok := cs.TakeRange(0, 4); !ok || len(taken) != 4 || len(contents(cs)) != 0 {
+		t.Fatalf("taking everything got %v, %v, left %v", taken, ok, contents(cs))
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	less := func(a, b interface{}) bool { return a.(int) < b.(int) }
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 20; trial++ {
+		items := make([]interface{}, 1+rnd.Intn(50))
+		for i := range items {
+			items[i] = rnd.Intn(100)
+		}
+		wantMin, wantMax := items[0], items[0]
+		for _, v := range items {
+			if less(v, wantMin) {
+				wantMin = v
+			}
+			if less(wantMax, v) {
+				wantMax = v
+			}
+		}
+		min, max, ok := newSlice(items...).MinMax(less)
+		if min != wantMin || max != wantMax || !ok {
+			t.Fatalf("MinMax of %v = %v, %v, %v, want %v, %v", items, min, max, ok, wantMin, wantMax)
+		}
+	}
+	if _, _, ok := NewConcurrentSlice().MinMax(less); ok {
+		t.Fatal("empty slice reported ok")
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import "sync"
+
+// Stack is a LIFO stack of typed items that can be safely shared between
+// goroutines.
+type Stack[T any] struct {
+	sync.Mutex
+	items []T
+}
+
+// NewStack creates a new stack.
+func NewStack[T any]() *Stack[T] {
+	s := &Stack[T]{
+		items: make([]T, 0),
+	}
+
+	return s
+}
+
+// Push adds an item to the top of the stack.
+func (s *Stack[T]) Push(item T) {
+	s.Lock()
+	defer s.Unlock()
+	s.items = append(s.items, item)
+}
+
+// Pop removes and returns the item at the top of the stack.
+func (s *Stack[T]) Pop() (T, bool) {
+	s.Lock()
+	defer s.Unlock()
+	var zero T
+	if len(s.items) == 0 {
+		return zero, false
+	}
+	last := len(s.items) - 1
+	item := s.items[last]
+	s.items[last] = zero
+	s.items = s.items[:last]
+	return item, true
+}
+
+// Peek returns the item at the top of the stack without removing it.
+func (s *Stack[T]) Peek() (T, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.items) == 0 {
+		var zero T
+		return zero, false
+	}
+	return s.items[len(s.items)-1], true
+}
+
+// Len returns the number of items in the stack.
+func (s *Stack[T]) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.items)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// minHeap is a heap of items ordered by less, smallest first.
+type minHeap struct {
+	items []interface{}
+	less  func(a, b interface{}) bool
+}
+
+func (h *minHeap) Len() int           { return len(h.items) }
+func (h *minHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *minHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *minHeap) Push(x interface{}) { h.items = append(h.items, x) }
+func (h *minHeap) Pop() (item interface{}) {
+	last := len(h.items) - 1
+	item, h.items = h.items[last], h.items[:last]
+	return item
+}
+
+// TopK returns the k largest items of the concurrent slice according to
+// less, largest first. It keeps a heap of k items while scanning, so it
+// runs in O(n log k) without sorting the whole slice. k is clamped to the
+// length of the slice.
+func (cs *ConcurrentSlice) TopK(k int, less func(a, b interface{}) bool) []interface{} {
+	cs.RLock()
+	defer cs.RUnlock()
+	if k > len(cs.items) {
+		k = len(cs.items)
+	}
+	if k <= 0 {
+		return []interface{}{}
+	}
+	h := &minHeap{items: make([]interface{}, 0, k), less: less}
+	for _, v := range cs.items {
+		if h.Len() < k {
+			heap.Push(h, v)
+		} else if less(h.items[0], v) {
+			h.items[0] = v
+			heap.Fix(h, 0)
+		}
+	}
+	sort.Slice(h.items, func(i, j int) bool { return less(h.items[j], h.items[i]) })
+	return h.items
+}
@@ -0,0 +1,52 @@
+package utils
+
+// SliceView is a live window over a sub-range of a concurrent slice.
+// Reads go through to the parent, so changes made to the parent inside
+// the range are visible through the view.
+type SliceView struct {
+	parent *ConcurrentSlice
+	start  int
+	end    int
+}
+
+// View returns a live view over the items in [start, end) of the
+// concurrent slice. Negative bounds are treated as zero.
+func (cs *ConcurrentSlice) View(start, end int) *SliceView {
+	if start < 0 {
+		start = 0
+	}
+	if end < start {
+		end = start
+	}
+	return &SliceView{parent: cs, start: start, end: end}
+}
+
+// Len returns the number of items currently visible through the view.
+// The view is clamped if the parent has shrunk below its end.
+func (sv *SliceView) Len() int {
+	sv.parent.RLock()
+	defer sv.parent.RUnlock()
+	return sv.len()
+}
+
+func (sv *SliceView) len() int {
+	end := sv.end
+	if n := len(sv.parent.items); n < end {
+		end = n
+	}
+	if end < sv.start {
+		return 0
+	}
+	return end - sv.start
+}
+
+// Get returns the item at index relative to the start of the view,
+// or nil if the index is outside the view.
+func (sv *SliceView) Get(index int) interface{} {
+	sv.parent.RLock()
+	defer sv.parent.RUnlock()
+	if index < 0 || index >= sv.len() {
+		return nil
+	}
+	return sv.parent.items[sv.start+index]
+}